@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	cptvframe "github.com/TheCacophonyProject/go-cptv/cptvframe"
+	lepton3 "github.com/TheCacophonyProject/lepton3"
+	"github.com/TheCacophonyProject/thermal-recorder/headers"
+	camera "github.com/feverscreen/fake-thermal-camera/fakecamera"
+)
+
+const (
+	leptonTelemetryBytes = 640
+	maxExamples          = 5
+)
+
+// names of the checks made on the stream
+const (
+	checkHeader       = "header"
+	checkFrameSize    = "frame-size"
+	checkTelemetry    = "telemetry"
+	checkTimeOn       = "time-on"
+	checkFrameCounter = "frame-counter"
+	checkFFC          = "ffc"
+	checkPixels       = "pixels"
+)
+
+type issue struct {
+	Frame  int    `json:"frame"`
+	Detail string `json:"detail"`
+}
+
+type checkResult struct {
+	Count    int     `json:"count"`
+	Examples []issue `json:"examples"`
+}
+
+// report is the result of checking a single connection from the fake camera
+type report struct {
+	Header   map[string]interface{}  `json:"header"`
+	Frames   int                     `json:"frames"`
+	Duration string                  `json:"duration"`
+	Checks   map[string]*checkResult `json:"checks"`
+}
+
+func (r *report) fail(check string, frame int, format string, a ...interface{}) {
+	result, ok := r.Checks[check]
+	if !ok {
+		result = &checkResult{}
+		r.Checks[check] = result
+	}
+	result.Count++
+	if len(result.Examples) < maxExamples {
+		result.Examples = append(result.Examples, issue{Frame: frame, Detail: fmt.Sprintf(format, a...)})
+	}
+}
+
+func (r *report) passed() bool {
+	return len(r.Checks) == 0
+}
+
+func (r *report) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "header: %v\n", r.Header)
+	fmt.Fprintf(&b, "frames: %d in %s\n", r.Frames, r.Duration)
+	if r.passed() {
+		b.WriteString("all checks passed\n")
+		return b.String()
+	}
+	names := make([]string, 0, len(r.Checks))
+	for name := range r.Checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		result := r.Checks[name]
+		fmt.Fprintf(&b, "%s: %d failures\n", name, result.Count)
+		for _, example := range result.Examples {
+			fmt.Fprintf(&b, "  frame %d: %s\n", example.Frame, example.Detail)
+		}
+	}
+	return b.String()
+}
+
+// checker validates the frames sent by the fake camera
+type checker struct {
+	minPixel   int
+	maxPixel   int
+	maxFFC     time.Duration
+	maxFrames  int
+	info       *headers.HeaderInfo
+	boson      bool
+	report     *report
+	prev       *cptvframe.Telemetry
+	ffcStarted time.Duration
+}
+
+// expectedFrameSize returns the frame size the header should have
+func (c *checker) expectedFrameSize() int {
+	pixels := c.info.ResX() * c.info.ResY() * 2
+	if c.boson {
+		return pixels + c.info.ResX()*2
+	}
+	return pixels + leptonTelemetryBytes
+}
+
+func (c *checker) checkHeader() bool {
+	h := c.info
+	c.report.Header = map[string]interface{}{
+		headers.XResolution: h.ResX(),
+		headers.YResolution: h.ResY(),
+		headers.FPS:         h.FPS(),
+		headers.FrameSize:   h.FrameSize(),
+		headers.Model:       h.Model(),
+		headers.Brand:       h.Brand(),
+	}
+	ok := true
+	if h.ResX() <= 0 || h.ResY() <= 0 {
+		c.report.fail(checkHeader, -1, "invalid resolution %dx%d", h.ResX(), h.ResY())
+		ok = false
+	}
+	if h.FPS() <= 0 {
+		c.report.fail(checkHeader, -1, "invalid fps %d", h.FPS())
+	}
+	if h.Model() == "" || h.Brand() == "" {
+		c.report.fail(checkHeader, -1, "missing model or brand")
+	}
+	if ok && h.FrameSize() != c.expectedFrameSize() {
+		c.report.fail(checkHeader, -1, "frame size %d should be %d for %dx%d %s", h.FrameSize(), c.expectedFrameSize(), h.ResX(), h.ResY(), h.Model())
+	}
+	return ok && h.FrameSize() > 0
+}
+
+// run checks frames from r until it closes or maxFrames have been read
+func (c *checker) run(r io.Reader) {
+	if !c.checkHeader() {
+		return
+	}
+	raw := make([]byte, c.info.FrameSize())
+	frame := cptvframe.NewFrame(c.info)
+	for c.maxFrames <= 0 || c.report.Frames < c.maxFrames {
+		n, err := io.ReadFull(r, raw)
+		if err == io.EOF {
+			return
+		} else if err == io.ErrUnexpectedEOF {
+			c.report.fail(checkFrameSize, c.report.Frames, "truncated frame of %d bytes", n)
+			return
+		} else if err != nil {
+			return
+		}
+		c.checkFrame(raw, frame)
+		c.report.Frames++
+	}
+}
+
+func (c *checker) checkFrame(raw []byte, frame *cptvframe.Frame) {
+	num := c.report.Frames
+	var err error
+	if c.boson {
+		err = camera.ParseBosonFrame(raw, frame)
+	} else {
+		err = lepton3.ParseRawFrame(raw, frame)
+	}
+	if err != nil {
+		c.report.fail(checkTelemetry, num, "could not parse frame %v", err)
+		return
+	}
+	t := frame.Status
+
+	if c.prev != nil {
+		if t.TimeOn <= c.prev.TimeOn {
+			c.report.fail(checkTimeOn, num, "time on went from %v to %v", c.prev.TimeOn, t.TimeOn)
+		}
+		if t.FrameCount <= c.prev.FrameCount {
+			c.report.fail(checkFrameCounter, num, "frame counter went from %d to %d", c.prev.FrameCount, t.FrameCount)
+		}
+	}
+	c.checkFFC(num, &t)
+	c.checkPixels(num, frame)
+	c.prev = &t
+}
+
+func (c *checker) checkFFC(num int, t *cptvframe.Telemetry) {
+	if t.LastFFCTime > t.TimeOn {
+		c.report.fail(checkFFC, num, "last FFC time %v is after time on %v", t.LastFFCTime, t.TimeOn)
+	}
+	if t.FFCState == lepton3.FFCNever && t.LastFFCTime > 0 {
+		c.report.fail(checkFFC, num, "FFC state is never but last FFC was at %v", t.LastFFCTime)
+	}
+	if c.prev == nil {
+		if t.FFCState == lepton3.FFCRunning {
+			c.ffcStarted = t.TimeOn
+		}
+		return
+	}
+	if c.prev.FFCState != lepton3.FFCNever && t.FFCState == lepton3.FFCNever {
+		c.report.fail(checkFFC, num, "FFC state went from %s to never", c.prev.FFCState)
+	}
+	if t.LastFFCTime < c.prev.LastFFCTime {
+		c.report.fail(checkFFC, num, "last FFC time went back from %v to %v", c.prev.LastFFCTime, t.LastFFCTime)
+	}
+	if t.FFCState == lepton3.FFCRunning {
+		if c.prev.FFCState != lepton3.FFCRunning {
+			c.ffcStarted = t.TimeOn
+		} else if t.TimeOn-c.ffcStarted > c.maxFFC {
+			c.report.fail(checkFFC, num, "FFC has been running for %v", t.TimeOn-c.ffcStarted)
+		}
+	}
+}
+
+func (c *checker) checkPixels(num int, frame *cptvframe.Frame) {
+	bad := 0
+	var example string
+	for y, row := range frame.Pix {
+		for x, val := range row {
+			if int(val) < c.minPixel || int(val) > c.maxPixel {
+				if bad == 0 {
+					example = fmt.Sprintf("(%d,%d)=%d", x, y, val)
+				}
+				bad++
+			}
+		}
+	}
+	if bad > 0 {
+		c.report.fail(checkPixels, num, "%d pixels outside %d-%d e.g. %s", bad, c.minPixel, c.maxPixel, example)
+	}
+}
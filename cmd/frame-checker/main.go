@@ -0,0 +1,126 @@
+// frame-checker - stand in for thermal-recorder that checks the frames sent by
+// the fake camera conform to the lepton-frames protocol
+//  Copyright (C) 2020, The Cacophony Project
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	arg "github.com/alexflint/go-arg"
+
+	"github.com/TheCacophonyProject/thermal-recorder/headers"
+)
+
+type argSpec struct {
+	Listen   string        `arg:"--listen" help:"socket to listen on for the fake camera e.g. unix:///var/run/lepton-frames or tcp://:5000"`
+	Dial     string        `arg:"--dial" help:"connect to a fake camera that is listening instead e.g. tcp://localhost:5000"`
+	Frames   int           `arg:"--frames" help:"stop after checking this many frames"`
+	MinPixel int           `arg:"--min-pixel" help:"lowest valid pixel value"`
+	MaxPixel int           `arg:"--max-pixel" help:"highest valid pixel value"`
+	MaxFFC   time.Duration `arg:"--max-ffc" help:"longest an FFC can run for"`
+	Report   string        `arg:"--report" help:"write the report as JSON to this file"`
+}
+
+func procArgs() argSpec {
+	args := argSpec{
+		Listen:   "unix:///var/run/lepton-frames",
+		MinPixel: 1,
+		MaxPixel: 1<<14 - 1,
+		MaxFFC:   5 * time.Second,
+	}
+	arg.MustParse(&args)
+	return args
+}
+
+func main() {
+	log.SetFlags(0)
+	args := procArgs()
+	conn, err := connect(args)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	info, err := headers.ReadHeaderInfo(r)
+	if err != nil {
+		log.Fatalf("could not read header %v", err)
+	}
+	c := &checker{
+		minPixel:  args.MinPixel,
+		maxPixel:  args.MaxPixel,
+		maxFFC:    args.MaxFFC,
+		maxFrames: args.Frames,
+		info:      info,
+		boson:     strings.HasPrefix(info.Model(), "boson"),
+		report:    &report{Checks: make(map[string]*checkResult)},
+	}
+	start := time.Now()
+	c.run(r)
+	c.report.Duration = time.Since(start).Round(time.Millisecond).String()
+
+	fmt.Print(c.report)
+	if args.Report != "" {
+		out, _ := json.MarshalIndent(c.report, "", "  ")
+		if err := ioutil.WriteFile(args.Report, out, 0644); err != nil {
+			log.Fatalf("could not write report %v", err)
+		}
+	}
+	if !c.report.passed() {
+		os.Exit(1)
+	}
+}
+
+// connect waits for the fake camera to connect, or dials it if it is listening
+func connect(args argSpec) (net.Conn, error) {
+	address := args.Listen
+	if args.Dial != "" {
+		address = args.Dial
+	}
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	network, addr := u.Scheme, u.Host
+	if network == "" || network == "unix" {
+		network, addr = "unix", u.Path
+	}
+
+	if args.Dial != "" {
+		log.Printf("dialing %s %s", network, addr)
+		return net.Dial(network, addr)
+	}
+	if network == "unix" {
+		os.Remove(addr)
+	}
+	listener, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	defer listener.Close()
+	log.Printf("waiting for the fake camera on %s %s", network, addr)
+	return listener.Accept()
+}
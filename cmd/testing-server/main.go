@@ -5,8 +5,12 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"net/http"
+	"net/url"
 	"os/exec"
+	"path/filepath"
+	"strconv"
 	"time"
 
 	"github.com/godbus/dbus"
@@ -18,8 +22,13 @@ import (
 )
 
 type argSpec struct {
-	CPTVDir   string `arg:"-c,--cptv-dir" help:"base path of cptv files"`
-	ConfigDir string `arg:"-c,--config" help:"path to configuration directory"`
+	CPTVDir   string   `arg:"-c,--cptv-dir" help:"base path of cptv files"`
+	ConfigDir string   `arg:"-c,--config" help:"path to configuration directory"`
+	Camera    string   `arg:"--camera" help:"camera to emulate: lepton3, lepton3.5, boson320, boson640 or WIDTHxHEIGHT, optionally followed by @FPS"`
+	Output    []string `arg:"--output" help:"where to send frames e.g. unix:///var/run/lepton-frames, tcp://:5000?listen=true, pipe:///tmp/frames, file:///tmp/frames.raw or stdout:"`
+	Capture   string   `arg:"--capture" help:"directory to record everything sent to the outputs in"`
+	Seed      int64    `arg:"--seed" help:"seed for generating frames, so a run can be repeated"`
+	StateFile string   `arg:"--state-file" help:"file to keep the queue and playback in, so they carry on after a restart"`
 }
 
 var (
@@ -36,7 +45,28 @@ func procArgs() argSpec {
 
 func main() {
 	args := procArgs()
-	go camera.RunCamera(args.CPTVDir, args.ConfigDir)
+	conf, err := camera.LoadConfig(args.ConfigDir)
+	if err != nil {
+		log.Printf("Error reading config %v\n", err)
+	} else {
+		if args.Camera != "" {
+			conf.Camera = args.Camera
+		}
+		if len(args.Output) > 0 {
+			conf.Output = args.Output
+		}
+		if args.Seed != 0 {
+			conf.Seed = args.Seed
+		}
+		if args.StateFile != "" {
+			conf.StateFile = args.StateFile
+		}
+		if args.Capture != "" {
+			captureDir, _ := filepath.Abs(args.Capture)
+			conf.Output = append(conf.Output, "capture://"+captureDir)
+		}
+		go camera.RunCamera(args.CPTVDir, conf)
+	}
 
 	if err := runServer(); err != nil {
 		log.Fatal(err)
@@ -53,7 +83,18 @@ func runServer() error {
 	router.HandleFunc("/", homeHandler)
 	router.HandleFunc("/triggerEvent/{type}", triggerEventHandler)
 	router.HandleFunc("/sendCPTVFrames", sendCPTVFramesHandler)
+	router.HandleFunc("/clearCPTVQueue", clearCPTVQueueHandler)
+	router.HandleFunc("/queue", queueHandler)
+	router.HandleFunc("/queue/remove", queueRemoveHandler)
+	router.HandleFunc("/queue/move", queueMoveHandler)
+	router.HandleFunc("/queue/insert", queueInsertHandler)
+	router.HandleFunc("/queue/replace", queueReplaceHandler).Methods("POST")
+	router.HandleFunc("/schedule", scheduleHandler)
+	router.HandleFunc("/schedule/cancel", scheduleCancelHandler)
 	router.HandleFunc("/playback", playbackHandler)
+	router.HandleFunc("/cameraHeader", cameraHeaderHandler)
+	router.HandleFunc("/clock", clockHandler)
+	router.HandleFunc("/status", statusHandler)
 
 	log.Fatal(http.ListenAndServe(":2040", router))
 	return nil
@@ -152,21 +193,178 @@ func triggerEventHandler(w http.ResponseWriter, r *http.Request) {
 
 func sendCPTVFramesHandler(w http.ResponseWriter, r *http.Request) {
 	queryVars := r.URL.Query()
-	fileName := queryVars.Get("cptv-file")
-	if fileName == "" {
-		queryVars.Set("cptv-file", "person.cptv")
+	setDefaultFile(queryVars)
+	if camera.Scheduled(queryVars) {
+		job, err := camera.Schedule(queryVars)
+		if err != nil {
+			logError(err.Error(), w, http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Schedule-Id", strconv.Itoa(job.ID))
+		writeJSON(w, job)
+		return
 	}
-	camera.Send(queryVars)
+	item := camera.Send(queryVars)
 
 	log.Printf("Sent CPTV Frames")
+	w.Header().Set("Seed", item.Params["seed"])
+	w.Header().Set("Queue-Id", strconv.Itoa(item.ID))
+	io.WriteString(w, "Success")
+}
+
+func setDefaultFile(queryVars url.Values) {
+	if queryVars.Get("cptv-file") == "" {
+		queryVars.Set("cptv-file", "person.cptv")
+	}
+}
+
+func clearCPTVQueueHandler(w http.ResponseWriter, r *http.Request) {
+	stop, _ := strconv.ParseBool(r.URL.Query().Get("stop"))
+	camera.ClearQueue(stop)
 	io.WriteString(w, "Success")
 }
 
+func queueHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, camera.QueueItems())
+}
+
+func queueRemoveHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		logError("'id' query parameter is missing or invalid", w, http.StatusBadRequest)
+		return
+	}
+	if err := camera.RemoveFromQueue(id); err != nil {
+		logError(err.Error(), w, http.StatusNotFound)
+		return
+	}
+	writeJSON(w, camera.QueueItems())
+}
+
+func queueMoveHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		logError("'id' query parameter is missing or invalid", w, http.StatusBadRequest)
+		return
+	}
+	position, err := strconv.Atoi(r.URL.Query().Get("position"))
+	if err != nil {
+		logError("'position' query parameter is missing or invalid", w, http.StatusBadRequest)
+		return
+	}
+	if err := camera.MoveInQueue(id, position); err != nil {
+		logError(err.Error(), w, http.StatusNotFound)
+		return
+	}
+	writeJSON(w, camera.QueueItems())
+}
+
+func queueInsertHandler(w http.ResponseWriter, r *http.Request) {
+	queryVars := r.URL.Query()
+	position := math.MaxInt32
+	if raw := queryVars.Get("position"); raw != "" {
+		var err error
+		if position, err = strconv.Atoi(raw); err != nil {
+			logError("'position' query parameter is invalid", w, http.StatusBadRequest)
+			return
+		}
+	}
+	queryVars.Del("position")
+	setDefaultFile(queryVars)
+	writeJSON(w, camera.InsertInQueue(queryVars, position))
+}
+
+func queueReplaceHandler(w http.ResponseWriter, r *http.Request) {
+	var items []map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&items); err != nil {
+		logError(fmt.Sprintf("Could not parse queue %v", err), w, http.StatusBadRequest)
+		return
+	}
+	all := make([]url.Values, len(items))
+	for i, item := range items {
+		all[i] = url.Values{}
+		for key, value := range item {
+			if s, ok := value.(string); ok {
+				all[i].Set(key, s)
+			} else {
+				// JSON values such as hotspots are sent as they were given
+				raw, _ := json.Marshal(value)
+				all[i].Set(key, string(raw))
+			}
+		}
+		setDefaultFile(all[i])
+	}
+	writeJSON(w, camera.ReplaceQueue(all))
+}
+
+func scheduleHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, camera.ScheduledJobs())
+}
+
+func scheduleCancelHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		logError("'id' query parameter is missing or invalid", w, http.StatusBadRequest)
+		return
+	}
+	if err := camera.CancelScheduled(id); err != nil {
+		logError(err.Error(), w, http.StatusNotFound)
+		return
+	}
+	writeJSON(w, camera.ScheduledJobs())
+}
+
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(value)
+}
+
 func playbackHandler(w http.ResponseWriter, r *http.Request) {
-	camera.Playback(r.URL.Query())
+	if err := camera.Playback(r.URL.Query()); err != nil {
+		logError(err.Error(), w, http.StatusBadRequest)
+		return
+	}
+	if r.URL.Query().Get("step") != "" {
+		frames, err := camera.Step(r.URL.Query())
+		if err != nil {
+			logError(err.Error(), w, http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(frames)
+		return
+	}
 	io.WriteString(w, "Success")
 }
 
+func cameraHeaderHandler(w http.ResponseWriter, r *http.Request) {
+	if err := camera.SetHeaderFields(r.URL.Query()); err != nil {
+		logError(err.Error(), w, http.StatusBadRequest)
+		return
+	}
+	header, err := camera.HeaderYAML()
+	if err != nil {
+		logError(fmt.Sprintf("Could not get camera header %v", err), w, http.StatusInternalServerError)
+		return
+	}
+	w.Write(header)
+}
+
+func clockHandler(w http.ResponseWriter, r *http.Request) {
+	status, err := camera.Clock(r.URL.Query())
+	if err != nil {
+		logError(err.Error(), w, http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+func statusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(camera.CameraStatus())
+}
+
 func logError(errorString string, w http.ResponseWriter, code int) {
 	log.Printf("Error: %s", errorString)
 	http.Error(w, fmt.Sprintf(errorString), code)
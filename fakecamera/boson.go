@@ -0,0 +1,132 @@
+package fakecamera
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	cptvframe "github.com/TheCacophonyProject/go-cptv/cptvframe"
+	lepton3 "github.com/TheCacophonyProject/lepton3"
+)
+
+const (
+	bosonMaxFPS    = 60
+	bosonPixelBits = 14
+	// marks the placeholder telemetry line, a real Boson doesn't send it
+	bosonMagic    = 0x4e534f42 // "BOSN"
+	bosonRevision = 1
+)
+
+// bosonEncoder writes frames like a Boson streaming 16 bit raw (Y16) video,
+// the first line of each frame is telemetry and every value is little endian
+type bosonEncoder struct{}
+
+// telemetrySize returns the size of the telemetry line, which is as wide as a
+// line of pixels
+func (bosonEncoder) telemetrySize(c *cameraModel) int {
+	return c.resX * 2
+}
+
+func (bosonEncoder) telemetryFields() interface{} {
+	return &bosonTelemetry{}
+}
+
+func (bosonEncoder) encode(frame *cptvframe.Frame, fields map[string]float64) *bytes.Buffer {
+	buf := new(bytes.Buffer)
+	tw := bosonTelemetryWords(frame.Status)
+	setTelemetryFields(&tw, configTelemetryFields())
+	setTelemetryFields(&tw, fields)
+	_ = binary.Write(buf, binary.LittleEndian, tw)
+	buf.Write(make([]byte, len(frame.Pix[0])*2-binary.Size(tw)))
+	pix := make([]byte, len(frame.Pix[0])*2)
+	for _, row := range frame.Pix {
+		for x, val := range row {
+			binary.LittleEndian.PutUint16(pix[x*2:], val)
+		}
+		buf.Write(pix)
+	}
+	return buf
+}
+
+// bosonTelemetry is the per frame metadata in the telemetry line of a Boson
+// frame. This is a placeholder layout made up for the fake camera, it is not
+// the telemetry line documented by FLIR, so decoders tested against it are not
+// tested against a real Boson.
+type bosonTelemetry struct {
+	Magic          uint32 // 0  always bosonMagic
+	Revision       uint16 // 2
+	PixelBits      uint16 // 3
+	FrameCounter   uint32 // 4
+	TimeOn         uint32 // 6  milliseconds since power on
+	FFCState       uint16 // 8  same values as the lepton status bits
+	Reserved9      uint16 // 9
+	LastFFCTime    uint32 // 10 milliseconds since power on of the last FFC
+	FPATemp        centiK // 12
+	FPATempLastFFC centiK // 13
+	FrameMean      uint16 // 14
+	Reserved15     uint16 // 15
+}
+
+func bosonTelemetryWords(t cptvframe.Telemetry) bosonTelemetry {
+	return bosonTelemetry{
+		Magic:          bosonMagic,
+		Revision:       bosonRevision,
+		PixelBits:      bosonPixelBits,
+		FrameCounter:   uint32(t.FrameCount),
+		TimeOn:         uint32(t.TimeOn.Milliseconds()),
+		FFCState:       uint16(ffcStateToStatus(t.FFCState) >> statusFFCStateShift),
+		LastFFCTime:    uint32(t.LastFFCTime.Milliseconds()),
+		FPATemp:        ToK(t.TempC),
+		FPATempLastFFC: ToK(t.LastFFCTempC),
+		FrameMean:      t.FrameMean,
+	}
+}
+
+// ParseBosonTelemetry reads the telemetry line of a Boson frame into t
+func ParseBosonTelemetry(raw []byte, t *cptvframe.Telemetry) error {
+	var tw bosonTelemetry
+	if err := binary.Read(bytes.NewReader(raw), binary.LittleEndian, &tw); err != nil {
+		return err
+	}
+	if tw.Magic != bosonMagic {
+		return errors.New("missing boson telemetry magic")
+	}
+	t.TimeOn = time.Duration(tw.TimeOn) * time.Millisecond
+	switch tw.FFCState {
+	case 0:
+		t.FFCState = lepton3.FFCNever
+	case 1:
+		t.FFCState = lepton3.FFCImminent
+	case 2:
+		t.FFCState = lepton3.FFCRunning
+	default:
+		t.FFCState = lepton3.FFCComplete
+	}
+	t.FrameCount = int(tw.FrameCounter)
+	t.FrameMean = tw.FrameMean
+	t.TempC = tw.FPATemp.ToC()
+	t.LastFFCTempC = tw.FPATempLastFFC.ToC()
+	t.LastFFCTime = time.Duration(tw.LastFFCTime) * time.Millisecond
+	return nil
+}
+
+// ParseBosonFrame converts a raw Boson frame into out, which must be sized for
+// the camera that sent it
+func ParseBosonFrame(raw []byte, out *cptvframe.Frame) error {
+	lineBytes := len(out.Pix[0]) * 2
+	if len(raw) != lineBytes*(len(out.Pix)+1) {
+		return errors.New("boson frame is the wrong size")
+	}
+	if err := ParseBosonTelemetry(raw[:lineBytes], &out.Status); err != nil {
+		return err
+	}
+	i := lineBytes
+	for y, row := range out.Pix {
+		for x := range row {
+			out.Pix[y][x] = binary.LittleEndian.Uint16(raw[i:])
+			i += 2
+		}
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package fakecamera
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	cptvframe "github.com/TheCacophonyProject/go-cptv/cptvframe"
+	lepton3 "github.com/TheCacophonyProject/lepton3"
+)
+
+// frameEncoder converts frames into the wire format of a camera
+type frameEncoder interface {
+	// telemetrySize returns the number of telemetry bytes sent with each frame
+	telemetrySize(c *cameraModel) int
+	// telemetryFields returns the telemetry struct of the camera, any of its
+	// fields can be set by name
+	telemetryFields() interface{}
+	// encode returns frame as sent by the camera, with the telemetry fields
+	// set to the raw values in fields
+	encode(frame *cptvframe.Frame, fields map[string]float64) *bytes.Buffer
+}
+
+// cameraModel describes a thermal camera that can be emulated
+type cameraModel struct {
+	brand  string
+	model  string
+	resX   int
+	resY   int
+	fps    int
+	maxFPS int
+	// pixels are clamped to this many bits, 0 sends all 16
+	pixelBits int
+	encoder   frameEncoder
+}
+
+var cameraModels = map[string]cameraModel{
+	"lepton3": {
+		brand:   lepton3.Brand,
+		model:   lepton3.Model,
+		resX:    lepton3.FrameCols,
+		resY:    lepton3.FrameRows,
+		fps:     lepton3.FramesHz,
+		maxFPS:  lepton3.FramesHz,
+		encoder: leptonEncoder{},
+	},
+	"lepton3.5": {
+		brand:   lepton3.Brand,
+		model:   "lepton3.5",
+		resX:    lepton3.FrameCols,
+		resY:    lepton3.FrameRows,
+		fps:     lepton3.FramesHz,
+		maxFPS:  lepton3.FramesHz,
+		encoder: leptonEncoder{},
+	},
+	"boson320": {
+		brand:     "flir",
+		model:     "boson320",
+		resX:      320,
+		resY:      256,
+		fps:       60,
+		maxFPS:    bosonMaxFPS,
+		pixelBits: bosonPixelBits,
+		encoder:   bosonEncoder{},
+	},
+	"boson640": {
+		brand:     "flir",
+		model:     "boson640",
+		resX:      640,
+		resY:      512,
+		fps:       60,
+		maxFPS:    bosonMaxFPS,
+		pixelBits: bosonPixelBits,
+		encoder:   bosonEncoder{},
+	},
+}
+
+var customCameraRe = regexp.MustCompile(`^(\d+)x(\d+)$`)
+
+// parseCameraModel returns the camera described by spec. spec is either
+// the name of a known model or a custom resolution WIDTHxHEIGHT, optionally
+// followed by @FPS e.g. "boson640@9" or "320x240@30"
+func parseCameraModel(spec string) (*cameraModel, error) {
+	name := strings.ToLower(strings.TrimSpace(spec))
+	fps := 0
+	if i := strings.LastIndex(name, "@"); i >= 0 {
+		var err error
+		fps, err = strconv.Atoi(name[i+1:])
+		if err != nil || fps <= 0 {
+			return nil, fmt.Errorf("invalid fps in camera %q", spec)
+		}
+		name = name[:i]
+	}
+
+	var c cameraModel
+	if known, ok := cameraModels[name]; ok {
+		c = known
+	} else if m := customCameraRe.FindStringSubmatch(name); m != nil {
+		c.brand = "fake"
+		c.model = "custom"
+		c.resX, _ = strconv.Atoi(m[1])
+		c.resY, _ = strconv.Atoi(m[2])
+		c.fps = lepton3.FramesHz
+		c.encoder = leptonEncoder{}
+		if c.resX == 0 || c.resY == 0 {
+			return nil, fmt.Errorf("invalid resolution in camera %q", spec)
+		}
+	} else {
+		return nil, fmt.Errorf("unknown camera %q", spec)
+	}
+
+	if fps > 0 {
+		if c.maxFPS > 0 && fps > c.maxFPS {
+			return nil, fmt.Errorf("%s supports at most %d fps", c.model, c.maxFPS)
+		}
+		c.fps = fps
+	}
+	return &c, nil
+}
+
+func (c *cameraModel) ResX() int {
+	return c.resX
+}
+
+func (c *cameraModel) ResY() int {
+	return c.resY
+}
+
+func (c *cameraModel) FPS() int {
+	return c.fps
+}
+
+func (c *cameraModel) Brand() string {
+	return c.brand
+}
+
+func (c *cameraModel) Model() string {
+	return c.model
+}
+
+// maxPixel returns the largest pixel value the camera sends
+func (c *cameraModel) maxPixel() uint16 {
+	if c.pixelBits == 0 {
+		return math.MaxUint16
+	}
+	return 1<<uint(c.pixelBits) - 1
+}
+
+// FrameSize returns the number of bytes sent for each frame (including telemetry)
+func (c *cameraModel) FrameSize() int {
+	return c.encoder.telemetrySize(c) + c.resX*c.resY*2
+}
@@ -0,0 +1,334 @@
+package fakecamera
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	cptvframe "github.com/TheCacophonyProject/go-cptv/cptvframe"
+	"github.com/TheCacophonyProject/thermal-recorder/headers"
+)
+
+const (
+	captureHeaderFile = "header.yaml"
+	captureFramesFile = "frames.raw"
+	captureIndexFile  = "index.csv"
+	captureDirFormat  = "20060102-150405.000"
+)
+
+var captureIndexColumns = []string{"frame", "offset", "size", "elapsed_ms", "time"}
+
+// captureSink records exactly what is sent to the consumers of the other
+// outputs. Each of their connections is saved in a new directory holding the
+// header, the raw frames and an index of when each frame was sent.
+type captureSink struct {
+	dir string
+}
+
+// captures record the connections of the other outputs, they are set when the
+// camera starts
+var captures []*captureSink
+
+func (s *captureSink) String() string {
+	return "capture " + s.dir
+}
+
+func (s *captureSink) listening() bool {
+	return false
+}
+
+func (s *captureSink) connect() (io.WriteCloser, error) {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return nil, err
+	}
+	// consumers connecting in the same millisecond get their own directory
+	name := time.Now().Format(captureDirFormat)
+	dir := path.Join(s.dir, name)
+	for i := 2; ; i++ {
+		err := os.Mkdir(dir, 0755)
+		if err == nil {
+			break
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		dir = path.Join(s.dir, fmt.Sprintf("%s-%d", name, i))
+	}
+	frames, err := os.Create(path.Join(dir, captureFramesFile))
+	if err != nil {
+		return nil, err
+	}
+	indexFile, err := os.Create(path.Join(dir, captureIndexFile))
+	if err != nil {
+		frames.Close()
+		return nil, err
+	}
+	index := csv.NewWriter(indexFile)
+	index.Write(captureIndexColumns)
+	log.Printf("Capturing frames to %s\n", dir)
+	return &captureWriter{dir: dir, frames: frames, indexFile: indexFile, index: index}, nil
+}
+
+// captureWriter saves the first write as the header and every write after
+// that as a frame
+type captureWriter struct {
+	dir       string
+	frames    *os.File
+	indexFile *os.File
+	index     *csv.Writer
+	header    bool
+	count     int
+	offset    int64
+	start     time.Duration
+}
+
+func (w *captureWriter) Write(b []byte) (int, error) {
+	if !w.header {
+		w.header = true
+		w.start = clock.now()
+		return len(b), ioutil.WriteFile(path.Join(w.dir, captureHeaderFile), b, 0644)
+	}
+	now := time.Now()
+	elapsed := clock.now() - w.start
+	n, err := w.frames.Write(b)
+	if err != nil {
+		return n, err
+	}
+	w.index.Write([]string{
+		strconv.Itoa(w.count),
+		strconv.FormatInt(w.offset, 10),
+		strconv.Itoa(n),
+		strconv.FormatInt(elapsed.Milliseconds(), 10),
+		now.Format(time.RFC3339Nano),
+	})
+	w.index.Flush()
+	w.count++
+	w.offset += int64(n)
+	return n, w.index.Error()
+}
+
+func (w *captureWriter) Close() error {
+	w.index.Flush()
+	w.indexFile.Close()
+	return w.frames.Close()
+}
+
+// recordingWriter records everything written to the connection of a consumer
+type recordingWriter struct {
+	io.WriteCloser
+	name     string
+	captures []io.WriteCloser
+}
+
+// recorded returns w, recording what is written to it in every capture
+func recorded(w io.WriteCloser, name string) io.WriteCloser {
+	if len(captures) == 0 {
+		return w
+	}
+	r := &recordingWriter{WriteCloser: w, name: name}
+	for _, s := range captures {
+		c, err := s.connect()
+		if err != nil {
+			log.Printf("Could not capture %s %v\n", name, err)
+			continue
+		}
+		r.captures = append(r.captures, c)
+	}
+	return r
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	n, err := w.WriteCloser.Write(b)
+	if n == 0 {
+		return n, err
+	}
+	for i := 0; i < len(w.captures); i++ {
+		if _, captureErr := w.captures[i].Write(b[:n]); captureErr != nil {
+			log.Printf("Stopped capturing %s %v\n", w.name, captureErr)
+			w.captures[i].Close()
+			w.captures = append(w.captures[:i], w.captures[i+1:]...)
+			i--
+		}
+	}
+	return n, err
+}
+
+func (w *recordingWriter) Close() error {
+	for _, c := range w.captures {
+		c.Close()
+	}
+	return w.WriteCloser.Close()
+}
+
+// rawFrameReader is implemented by sources that hold frames already encoded,
+// these are sent unchanged
+type rawFrameReader interface {
+	// NextRaw returns the next frame and how long after the previous frame
+	// it should be sent
+	NextRaw() ([]byte, time.Duration, error)
+}
+
+type captureEntry struct {
+	offset  int64
+	size    int
+	elapsed time.Duration
+}
+
+// captureReader replays a capture byte for byte with the original timing
+type captureReader struct {
+	frames *os.File
+	index  []captureEntry
+	fps    int
+	pos    int
+	start  int
+	stop   int
+	repeat int
+	played int
+}
+
+func NewCaptureReader(p *params) (*captureReader, error) {
+	dir := p.capture()
+	if !path.IsAbs(dir) {
+		dir = path.Join(cptvDir, dir)
+	}
+	header, err := readCaptureHeader(dir)
+	if err != nil {
+		return nil, err
+	}
+	if header.FrameSize() != camera.FrameSize() {
+		return nil, fmt.Errorf("capture %s has %d byte frames but the camera sends %d", dir, header.FrameSize(), camera.FrameSize())
+	}
+	if header.Model() != camera.Model() {
+		log.Printf("capture %s was made with a %s camera\n", dir, header.Model())
+	}
+	index, err := readCaptureIndex(dir)
+	if err != nil {
+		return nil, err
+	}
+	start, stop := p.start(), p.end()
+	if stop == 0 || stop >= len(index) {
+		stop = len(index) - 1
+	}
+	if start < 0 || start >= len(index) {
+		return nil, fmt.Errorf("start %d is outside the %d frames of capture %s", start, len(index), dir)
+	}
+	if start > stop {
+		return nil, fmt.Errorf("end %d is before start %d", stop, start)
+	}
+	frames, err := os.Open(path.Join(dir, captureFramesFile))
+	if err != nil {
+		return nil, err
+	}
+
+	r := &captureReader{
+		frames: frames,
+		index:  index,
+		fps:    header.FPS(),
+		pos:    start,
+		start:  start,
+		stop:   stop,
+		repeat: p.repeat(),
+	}
+	return r, nil
+}
+
+func readCaptureHeader(dir string) (*headers.HeaderInfo, error) {
+	raw, err := ioutil.ReadFile(path.Join(dir, captureHeaderFile))
+	if err != nil {
+		return nil, err
+	}
+	return headers.ReadHeaderInfo(bufio.NewReader(bytes.NewReader(raw)))
+}
+
+func readCaptureIndex(dir string) ([]captureEntry, error) {
+	f, err := os.Open(path.Join(dir, captureIndexFile))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) < 2 {
+		return nil, errors.New("capture has no frames")
+	}
+	index := make([]captureEntry, 0, len(rows)-1)
+	for _, row := range rows[1:] {
+		offset, err := strconv.ParseInt(row[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(row[2])
+		if err != nil {
+			return nil, err
+		}
+		elapsed, err := strconv.ParseInt(row[3], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		index = append(index, captureEntry{offset: offset, size: size, elapsed: time.Duration(elapsed) * time.Millisecond})
+	}
+	return index, nil
+}
+
+func (r *captureReader) NextRaw() ([]byte, time.Duration, error) {
+	if r.pos > r.stop {
+		r.played++
+		if finished(r.played, r.repeat) {
+			return nil, 0, io.EOF
+		}
+		r.pos = r.start
+	}
+	entry := r.index[r.pos]
+	var wait time.Duration
+	if r.pos > r.start {
+		wait = entry.elapsed - r.index[r.pos-1].elapsed
+	} else if r.fps > 0 {
+		wait = time.Second / time.Duration(r.fps)
+	}
+	frame := make([]byte, entry.size)
+	if _, err := r.frames.ReadAt(frame, entry.offset); err != nil {
+		return nil, 0, err
+	}
+	r.pos++
+	return frame, wait, nil
+}
+
+func (r *captureReader) position() int {
+	return r.pos
+}
+
+// seek moves to frame of the capture, keeping inside start and end
+func (r *captureReader) seek(frame int) error {
+	if frame < r.start {
+		frame = r.start
+	}
+	if frame > r.stop+1 {
+		frame = r.stop + 1
+	}
+	r.pos = frame
+	return nil
+}
+
+// Next is not supported, captures are only sent raw
+func (r *captureReader) Next() (*cptvframe.Frame, error) {
+	return nil, errors.New("capture frames can only be read raw")
+}
+
+func (r *captureReader) FPS() int {
+	return r.fps
+}
+
+func (r *captureReader) Close() {
+	r.frames.Close()
+}
@@ -0,0 +1,235 @@
+package fakecamera
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// clock modes
+const (
+	clockReal   = "real"   // follows the wall clock
+	clockFast   = "fast"   // runs speed times faster than the wall clock
+	clockManual = "manual" // only moves when advanced
+
+	defaultClockSpeed = 10
+)
+
+// virtualClock is the time of the camera, TimeOn, FFCs and the frame rate all
+// follow it. Tests can run it faster than real time or advance it by hand.
+type virtualClock struct {
+	mu       sync.Mutex
+	moved    *sync.Cond
+	mode     string
+	speed    float64
+	base     time.Duration // camera time at wallBase
+	wallBase time.Time
+	// in manual mode frames are sent at the times they are due, up to the
+	// time the clock has been advanced to
+	cursor time.Duration
+	wakes  int
+	// closed and replaced when the clock changes, to interrupt real and fast
+	// sleeps
+	changed chan struct{}
+}
+
+var clock = newVirtualClock()
+
+func newVirtualClock() *virtualClock {
+	c := &virtualClock{mode: clockReal, speed: 1, wallBase: time.Now(), changed: make(chan struct{})}
+	c.moved = sync.NewCond(&c.mu)
+	return c
+}
+
+// ClockStatus describes the camera clock
+type ClockStatus struct {
+	Mode  string  `json:"mode"`
+	Speed float64 `json:"speed"`
+	Time  string  `json:"time"`
+}
+
+// now returns how long the camera has been on
+func (c *virtualClock) now() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.nowLocked()
+}
+
+func (c *virtualClock) nowLocked() time.Duration {
+	if c.mode == clockManual {
+		return c.cursor
+	}
+	return c.base + time.Duration(float64(time.Since(c.wallBase))*c.speed)
+}
+
+// realTime returns if the clock is following the wall clock
+func (c *virtualClock) realTime() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.mode == clockReal
+}
+
+// sleep waits until d has passed on the camera clock, or wake is called. It
+// returns false if it was woken.
+func (c *virtualClock) sleep(d time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	wakes := c.wakes
+	if c.mode != clockManual {
+		// the wait is worked out again whenever the clock changes speed
+		until := c.nowLocked() + d
+		for c.mode != clockManual && c.wakes == wakes {
+			wait := time.Duration(float64(until-c.nowLocked()) / c.speed)
+			if wait <= 0 {
+				return true
+			}
+			changed := c.changed
+			c.mu.Unlock()
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-changed:
+			}
+			timer.Stop()
+			c.mu.Lock()
+		}
+		return c.wakes == wakes
+	}
+	c.cursor += d
+	for c.mode == clockManual && c.base < c.cursor && c.wakes == wakes {
+		c.moved.Wait()
+	}
+	return c.wakes == wakes
+}
+
+// skipTo moves the clock on to t straight away, for frames sent without
+// waiting. A clock that is already past t isn't moved back.
+func (c *virtualClock) skipTo(t time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.mode == clockManual {
+		if c.cursor < t {
+			c.cursor = t
+		}
+		if c.base < c.cursor {
+			c.base = c.cursor
+		}
+		return
+	}
+	if c.nowLocked() < t {
+		c.base = t
+		c.wallBase = time.Now()
+	}
+}
+
+// sync catches a manual clock up to the time it has been advanced to, this is
+// called when sending starts so frames aren't sent for time that has passed
+func (c *virtualClock) sync() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.mode == clockManual {
+		c.cursor = c.base
+	}
+}
+
+// wake interrupts anything sleeping, so it can see playback has changed
+func (c *virtualClock) wake() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.wakes++
+	c.broadcast()
+}
+
+// broadcast tells anything sleeping that the clock has changed, c.mu must be
+// held
+func (c *virtualClock) broadcast() {
+	c.moved.Broadcast()
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// advance moves a manual clock on by d
+func (c *virtualClock) advance(d time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.mode != clockManual {
+		return fmt.Errorf("only a manual clock can be advanced, the clock is %s", c.mode)
+	}
+	if d < 0 {
+		return fmt.Errorf("can not advance the clock by %v", d)
+	}
+	c.base += d
+	c.broadcast()
+	return nil
+}
+
+// set changes the mode of the clock, the camera time carries on from where it
+// is now
+func (c *virtualClock) set(mode string, speed float64) error {
+	switch mode {
+	case clockReal:
+		speed = 1
+	case clockFast:
+		if speed <= 0 {
+			return fmt.Errorf("invalid clock speed %v", speed)
+		}
+	case clockManual:
+	default:
+		return fmt.Errorf("unknown clock mode %q", mode)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.mode != clockManual {
+		c.base = c.nowLocked()
+	}
+	c.cursor = c.base
+	c.wallBase = time.Now()
+	c.mode = mode
+	c.speed = speed
+	c.broadcast()
+	return nil
+}
+
+func (c *virtualClock) status() ClockStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.nowLocked()
+	if c.mode == clockManual {
+		now = c.base
+	}
+	return ClockStatus{Mode: c.mode, Speed: c.speed, Time: now.String()}
+}
+
+// Clock changes the camera clock with the mode, speed and advance in values
+// and returns its status
+func Clock(values url.Values) (ClockStatus, error) {
+	if mode := values.Get("mode"); mode != "" {
+		speed := clock.status().Speed
+		if speed == 1 {
+			speed = defaultClockSpeed
+		}
+		if raw := values.Get("speed"); raw != "" {
+			var err error
+			if speed, err = strconv.ParseFloat(raw, 64); err != nil {
+				return clock.status(), fmt.Errorf("invalid clock speed %q", raw)
+			}
+		}
+		if err := clock.set(mode, speed); err != nil {
+			return clock.status(), err
+		}
+		log.Printf("Clock is %s at %vx\n", mode, clock.status().Speed)
+	}
+	if raw := values.Get("advance"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return clock.status(), fmt.Errorf("invalid clock advance %q", raw)
+		}
+		if err := clock.advance(d); err != nil {
+			return clock.status(), err
+		}
+	}
+	return clock.status(), nil
+}
@@ -0,0 +1,113 @@
+package fakecamera
+
+import (
+	"time"
+
+	goconfig "github.com/TheCacophonyProject/go-config"
+)
+
+const configKey = "fake-camera"
+
+// Config holds the settings for the fake camera, these are read from the
+// "fake-camera" section of the cacophony config file.
+type Config struct {
+	Camera        string                 `mapstructure:"camera"`
+	Serial        string                 `mapstructure:"serial"`
+	Firmware      string                 `mapstructure:"firmware"`
+	Headers       map[string]interface{} `mapstructure:"headers"`
+	Output        []string               `mapstructure:"output"`
+	RetryInterval time.Duration          `mapstructure:"retry-interval"`
+	Telemetry     map[string]interface{} `mapstructure:"telemetry"`
+	Sensor        SensorConfig           `mapstructure:"sensor"`
+	FFC           FFCConfig              `mapstructure:"ffc"`
+	Clock         string                 `mapstructure:"clock"`
+	ClockSpeed    float64                `mapstructure:"clock-speed"`
+	Seed          int64                  `mapstructure:"seed"`
+	FrameJitter   time.Duration          `mapstructure:"frame-jitter"`
+	StateFile     string                 `mapstructure:"state-file"`
+	Faults        FaultConfig            `mapstructure:"faults"`
+}
+
+// SensorConfig holds the settings of the thermal model of the camera core,
+// temperatures are in Celsius
+type SensorConfig struct {
+	Ambient     float64       `mapstructure:"ambient"`
+	SelfHeating float64       `mapstructure:"self-heating"`
+	WarmUp      time.Duration `mapstructure:"warm-up"`
+	Drift       float64       `mapstructure:"drift"`
+	DriftPeriod time.Duration `mapstructure:"drift-period"`
+	PixelDrift  float64       `mapstructure:"pixel-drift"`
+}
+
+// FFCConfig holds the settings of automatic flat field corrections
+type FFCConfig struct {
+	Auto          bool          `mapstructure:"auto"`
+	Interval      time.Duration `mapstructure:"interval"`
+	TempDelta     float64       `mapstructure:"temp-delta"`
+	Imminent      time.Duration `mapstructure:"imminent"`
+	Duration      time.Duration `mapstructure:"duration"`
+	ShutterFrames string        `mapstructure:"shutter-frames"`
+}
+
+// FaultConfig holds the faults added to the frames sent, like those of a
+// misbehaving sensor. Chances are from 0 to 1 for each frame.
+type FaultConfig struct {
+	Drop           float64 `mapstructure:"drop"`            // chance of a frame not being sent
+	Duplicate      float64 `mapstructure:"duplicate"`       // chance of a frame being sent twice
+	Freeze         float64 `mapstructure:"freeze"`          // chance of the stream freezing on a frame
+	FreezeFrames   int     `mapstructure:"freeze-frames"`   // frames a freeze lasts, 0 is a second
+	PixelFlips     float64 `mapstructure:"pixel-flips"`     // chance of a bit of the pixels being flipped
+	TelemetryFlips float64 `mapstructure:"telemetry-flips"` // chance of a bit of the telemetry being flipped
+	Truncate       float64 `mapstructure:"truncate"`        // chance of a frame being cut short
+	Shuffle        float64 `mapstructure:"shuffle"`         // chance of a frame being sent after the next one
+}
+
+func defaultFFCConfig() FFCConfig {
+	return FFCConfig{
+		Auto:          true,
+		Interval:      3 * time.Minute,
+		TempDelta:     1.5,
+		Imminent:      2 * time.Second,
+		Duration:      time.Second,
+		ShutterFrames: shutterFrozen,
+	}
+}
+
+func defaultSensorConfig() SensorConfig {
+	return SensorConfig{
+		Ambient:     22,
+		SelfHeating: 8,
+		WarmUp:      10 * time.Minute,
+		Drift:       1,
+		DriftPeriod: time.Hour,
+	}
+}
+
+func defaultConfig(lepton goconfig.Lepton) Config {
+	return Config{
+		Camera:        "lepton3.5",
+		Output:        []string{"unix://" + lepton.FrameOutput},
+		RetryInterval: 10 * time.Second,
+		Sensor:        defaultSensorConfig(),
+		FFC:           defaultFFCConfig(),
+		Clock:         clockReal,
+		ClockSpeed:    defaultClockSpeed,
+	}
+}
+
+// LoadConfig reads the fake camera settings from the config file in configDir
+func LoadConfig(configDir string) (*Config, error) {
+	configRW, err := goconfig.New(configDir)
+	if err != nil {
+		return nil, err
+	}
+	lepton := goconfig.DefaultLepton()
+	if err := configRW.Unmarshal(goconfig.LeptonKey, &lepton); err != nil {
+		return nil, err
+	}
+	conf := defaultConfig(lepton)
+	if err := configRW.Unmarshal(configKey, &conf); err != nil {
+		return nil, err
+	}
+	return &conf, nil
+}
@@ -0,0 +1,77 @@
+package fakecamera
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// frameCounter is the frame counter of the camera, it counts every frame made
+// since the camera started no matter which request or consumer it is for
+type frameCounter struct {
+	mu    sync.Mutex
+	count int
+}
+
+var cameraCounter = &frameCounter{}
+
+// next counts a frame and returns its number, after skipping gap frames or
+// starting again from 0 if reset
+func (c *frameCounter) next(gap int, reset bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if reset {
+		c.count = 0
+	}
+	c.count += gap + 1
+	return c.count
+}
+
+// counterEvents are deliberate faults in the frame counter, by the frame of
+// the request they happen at
+type counterEvents struct {
+	gaps   map[int]int
+	resets map[int]bool
+}
+
+// parseCounterEvents reads gaps as a list of FRAME:SIZE and resets as a list
+// of frames, e.g. "10:5,20:100" and "30"
+func parseCounterEvents(gaps, resets string) (*counterEvents, error) {
+	e := &counterEvents{gaps: map[int]int{}, resets: map[int]bool{}}
+	for _, gap := range splitList(gaps) {
+		parts := strings.SplitN(gap, ":", 2)
+		frame, err := strconv.Atoi(parts[0])
+		size := 1
+		if err == nil && len(parts) == 2 {
+			size, err = strconv.Atoi(parts[1])
+		}
+		if err != nil || frame < 0 || size < 0 {
+			return nil, fmt.Errorf("invalid counter gap %q", gap)
+		}
+		e.gaps[frame] = size
+	}
+	for _, reset := range splitList(resets) {
+		frame, err := strconv.Atoi(reset)
+		if err != nil || frame < 0 {
+			return nil, fmt.Errorf("invalid counter reset %q", reset)
+		}
+		e.resets[frame] = true
+	}
+	return e, nil
+}
+
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// nextCount returns the frame counter for frame n of the request
+func (e *counterEvents) nextCount(n int) int {
+	return cameraCounter.next(e.gaps[n], e.resets[n])
+}
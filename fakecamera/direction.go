@@ -0,0 +1,127 @@
+package fakecamera
+
+import (
+	"errors"
+	"io"
+
+	cptvframe "github.com/TheCacophonyProject/go-cptv/cptvframe"
+)
+
+// directions CPTV files can be played in
+const (
+	directionForward  = "forward"
+	directionReverse  = "reverse"
+	directionPingPong = "pingpong" // forwards then backwards
+)
+
+// cachedReader plays the frames of a CPTV file from memory, so they can be
+// played backwards. Frames are kept at the resolution of the file and scaled
+// to the camera as they are played.
+type cachedReader struct {
+	frames    []*cptvframe.Frame
+	scaled    *cptvframe.Frame
+	fps       int
+	first     int // frame of the file frames starts at
+	direction string
+	repeat    int
+	played    int
+	next      int
+	step      int
+	last      int
+}
+
+// newCachedReader reads all frames of r from its start to its end
+func newCachedReader(r *cptvReader, direction string) (*cachedReader, error) {
+	defer r.Close()
+	c := &cachedReader{
+		fps:       r.FPS(),
+		scaled:    r.scaled,
+		first:     r.frameNum,
+		direction: direction,
+		repeat:    r.repeat,
+		step:      1,
+	}
+	r.repeat = 1
+	for {
+		frame, err := r.nextSource()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		c.frames = append(c.frames, frame.CreateCopy())
+	}
+	if len(c.frames) == 0 {
+		return nil, errors.New("cptv file has no frames to play")
+	}
+	if direction == directionReverse {
+		c.next = len(c.frames) - 1
+		c.step = -1
+	}
+	return c, nil
+}
+
+func (c *cachedReader) Next() (*cptvframe.Frame, error) {
+	if c.next < 0 || c.next >= len(c.frames) {
+		c.played++
+		if finished(c.played, c.repeat) {
+			return nil, io.EOF
+		}
+		c.turn()
+	}
+	frame := c.frames[c.next]
+	c.last = c.next
+	c.next += c.step
+	if c.scaled == nil {
+		return frame, nil
+	}
+	scaleFrame(frame, c.scaled)
+	return c.scaled, nil
+}
+
+// turn starts the next play of the frames, ping-pong turns around without
+// sending the end frame twice
+func (c *cachedReader) turn() {
+	switch c.direction {
+	case directionReverse:
+		c.next = len(c.frames) - 1
+	case directionPingPong:
+		c.step = -c.step
+		c.next += 2 * c.step
+		if c.next < 0 || c.next >= len(c.frames) {
+			c.next -= c.step
+		}
+	default:
+		c.next = 0
+	}
+}
+
+func (c *cachedReader) FPS() int {
+	return c.fps
+}
+
+func (c *cachedReader) Close() {
+}
+
+// position returns the frame of the file to be played next
+func (c *cachedReader) position() int {
+	return c.first + c.next
+}
+
+// seek moves to frame of the file, carrying on in the same direction
+func (c *cachedReader) seek(frame int) error {
+	c.next = frame - c.first
+	if c.next < 0 {
+		c.next = 0
+	}
+	if c.next > len(c.frames) {
+		c.next = len(c.frames)
+	}
+	return nil
+}
+
+// lastFrame returns the frame of the file played last
+func (c *cachedReader) lastFrame() int {
+	return c.first + c.last
+}
@@ -0,0 +1,98 @@
+package fakecamera
+
+import (
+	"io"
+	"reflect"
+	"testing"
+
+	cptvframe "github.com/TheCacophonyProject/go-cptv/cptvframe"
+)
+
+// testCachedReader returns a reader of count frames set up as newCachedReader
+// would
+func testCachedReader(count int, direction string, repeat int) *cachedReader {
+	c := &cachedReader{direction: direction, repeat: repeat, step: 1}
+	for i := 0; i < count; i++ {
+		c.frames = append(c.frames, &cptvframe.Frame{})
+	}
+	if direction == directionReverse {
+		c.next = count - 1
+		c.step = -1
+	}
+	return c
+}
+
+// played returns the order frames of c are played in, stopping after max
+// frames
+func played(t *testing.T, c *cachedReader, max int) []int {
+	var order []int
+	for len(order) < max {
+		frame, err := c.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, f := range c.frames {
+			if f == frame {
+				order = append(order, i)
+			}
+		}
+	}
+	return order
+}
+
+func TestCachedReaderOrder(t *testing.T) {
+	tests := []struct {
+		count     int
+		direction string
+		repeat    int
+		want      []int
+	}{
+		{3, directionForward, 2, []int{0, 1, 2, 0, 1, 2}},
+		{3, directionReverse, 2, []int{2, 1, 0, 2, 1, 0}},
+		{3, directionPingPong, 3, []int{0, 1, 2, 1, 0, 1, 2}},
+		{4, directionPingPong, repeatForever, []int{0, 1, 2, 3, 2, 1, 0, 1, 2, 3}},
+		{2, directionPingPong, repeatForever, []int{0, 1, 0, 1, 0}},
+
+		// a single frame is played once each time
+		{1, directionForward, 3, []int{0, 0, 0}},
+		{1, directionReverse, 3, []int{0, 0, 0}},
+		{1, directionPingPong, 3, []int{0, 0, 0}},
+		{1, directionPingPong, repeatForever, []int{0, 0, 0, 0, 0}},
+	}
+	for _, test := range tests {
+		c := testCachedReader(test.count, test.direction, test.repeat)
+		max := len(test.want)
+		if test.repeat != repeatForever {
+			// check nothing is played after the last repeat
+			max++
+		}
+		if got := played(t, c, max); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%d frames %s repeat %d played %v, want %v", test.count, test.direction, test.repeat, got, test.want)
+		}
+	}
+}
+
+func TestCachedReaderSeek(t *testing.T) {
+	c := testCachedReader(5, directionPingPong, repeatForever)
+	c.first = 10
+	played(t, c, 2)
+	if got := c.lastFrame(); got != 11 {
+		t.Errorf("last frame %d, want 11", got)
+	}
+	c.seek(13)
+	if got := c.position(); got != 13 {
+		t.Errorf("position after seeking to 13 is %d", got)
+	}
+	if got, want := played(t, c, 4), []int{3, 4, 3, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("played %v after seeking, want %v", got, want)
+	}
+	// seeking past the end turns around
+	c.step = 1
+	c.seek(20)
+	if got, want := played(t, c, 2), []int{3, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("played %v after seeking past the end, want %v", got, want)
+	}
+}
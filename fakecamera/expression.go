@@ -0,0 +1,261 @@
+package fakecamera
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// exprVars are the values an expression can use
+type exprVars struct {
+	n   float64 // frames sent for this request, starting at 0
+	t   float64 // seconds since the request started playing
+	fps float64 // camera frame rate
+}
+
+// expression is a compiled arithmetic expression, e.g. "30 + t/60" or
+// "max(28, 34 - 0.01*n)"
+type expression func(v *exprVars) float64
+
+type exprFunc struct {
+	args int
+	call func(args []float64) float64
+}
+
+var exprFuncs = map[string]exprFunc{
+	"sin":   {1, func(a []float64) float64 { return math.Sin(a[0]) }},
+	"cos":   {1, func(a []float64) float64 { return math.Cos(a[0]) }},
+	"abs":   {1, func(a []float64) float64 { return math.Abs(a[0]) }},
+	"floor": {1, func(a []float64) float64 { return math.Floor(a[0]) }},
+	"sqrt":  {1, func(a []float64) float64 { return math.Sqrt(a[0]) }},
+	"min":   {2, func(a []float64) float64 { return math.Min(a[0], a[1]) }},
+	"max":   {2, func(a []float64) float64 { return math.Max(a[0], a[1]) }},
+}
+
+func constExpression(value float64) expression {
+	return func(*exprVars) float64 { return value }
+}
+
+// parseExpression compiles an expression made of numbers, the variables n, t
+// and fps, + - * / %, brackets and the functions in exprFuncs
+func parseExpression(src string) (expression, error) {
+	p := &exprParser{src: src}
+	expr, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		return nil, fmt.Errorf("unexpected %q in expression %q", p.src[p.pos:], src)
+	}
+	return expr, nil
+}
+
+type exprParser struct {
+	src string
+	pos int
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.src) && p.src[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+// next returns the next character without consuming it, or 0 at the end
+func (p *exprParser) next() byte {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *exprParser) sum() (expression, error) {
+	left, err := p.product()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.next()
+		if op != '+' && op != '-' {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.product()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		if op == '+' {
+			left = func(v *exprVars) float64 { return l(v) + right(v) }
+		} else {
+			left = func(v *exprVars) float64 { return l(v) - right(v) }
+		}
+	}
+}
+
+func (p *exprParser) product() (expression, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.next()
+		if op != '*' && op != '/' && op != '%' {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		switch op {
+		case '*':
+			left = func(v *exprVars) float64 { return l(v) * right(v) }
+		case '/':
+			left = func(v *exprVars) float64 { return l(v) / right(v) }
+		default:
+			left = func(v *exprVars) float64 { return math.Mod(l(v), right(v)) }
+		}
+	}
+}
+
+func (p *exprParser) unary() (expression, error) {
+	if p.next() == '-' {
+		p.pos++
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(v *exprVars) float64 { return -operand(v) }, nil
+	}
+	return p.operand()
+}
+
+func (p *exprParser) operand() (expression, error) {
+	c := p.next()
+	switch {
+	case c == '(':
+		p.pos++
+		expr, err := p.sum()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ')' {
+			return nil, fmt.Errorf("missing ) in expression %q", p.src)
+		}
+		p.pos++
+		return expr, nil
+	case c == '.' || unicode.IsDigit(rune(c)):
+		return p.number()
+	case unicode.IsLetter(rune(c)):
+		return p.name()
+	}
+	return nil, fmt.Errorf("unexpected end of expression %q", p.src)
+}
+
+func (p *exprParser) number() (expression, error) {
+	start := p.pos
+	digits := "0123456789."
+	hex := strings.HasPrefix(p.src[p.pos:], "0x") || strings.HasPrefix(p.src[p.pos:], "0X")
+	if hex {
+		p.pos += 2
+		digits = "0123456789abcdefABCDEF"
+	}
+	for p.pos < len(p.src) && strings.IndexByte(digits, p.src[p.pos]) >= 0 {
+		p.pos++
+	}
+	if !hex {
+		p.exponent()
+	}
+	text := p.src[start:p.pos]
+	var value float64
+	var err error
+	if hex {
+		var i uint64
+		i, err = strconv.ParseUint(text[2:], 16, 64)
+		value = float64(i)
+	} else {
+		value, err = strconv.ParseFloat(text, 64)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid number %q in expression %q", text, p.src)
+	}
+	return constExpression(value), nil
+}
+
+// exponent consumes the exponent of a number such as 1e3 or 2.5E-2, if there
+// is one
+func (p *exprParser) exponent() {
+	if p.pos >= len(p.src) || (p.src[p.pos] != 'e' && p.src[p.pos] != 'E') {
+		return
+	}
+	end := p.pos + 1
+	if end < len(p.src) && (p.src[end] == '+' || p.src[end] == '-') {
+		end++
+	}
+	if end >= len(p.src) || !unicode.IsDigit(rune(p.src[end])) {
+		return
+	}
+	for end < len(p.src) && unicode.IsDigit(rune(p.src[end])) {
+		end++
+	}
+	p.pos = end
+}
+
+func (p *exprParser) name() (expression, error) {
+	start := p.pos
+	for p.pos < len(p.src) && unicode.IsLetter(rune(p.src[p.pos])) {
+		p.pos++
+	}
+	name := strings.ToLower(p.src[start:p.pos])
+	switch name {
+	case "n":
+		return func(v *exprVars) float64 { return v.n }, nil
+	case "t":
+		return func(v *exprVars) float64 { return v.t }, nil
+	case "fps":
+		return func(v *exprVars) float64 { return v.fps }, nil
+	case "pi":
+		return constExpression(math.Pi), nil
+	}
+
+	fn, ok := exprFuncs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown name %q in expression %q", name, p.src)
+	}
+	if p.next() != '(' {
+		return nil, fmt.Errorf("missing ( after %s in expression %q", name, p.src)
+	}
+	p.pos++
+	var args []expression
+	for {
+		arg, err := p.sum()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if p.next() != ',' {
+			break
+		}
+		p.pos++
+	}
+	if p.next() != ')' {
+		return nil, fmt.Errorf("missing ) after %s in expression %q", name, p.src)
+	}
+	p.pos++
+	if len(args) != fn.args {
+		return nil, fmt.Errorf("%s takes %d arguments in expression %q", name, fn.args, p.src)
+	}
+	return func(v *exprVars) float64 {
+		values := make([]float64, len(args))
+		for i, arg := range args {
+			values[i] = arg(v)
+		}
+		return fn.call(values)
+	}, nil
+}
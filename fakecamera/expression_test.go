@@ -0,0 +1,100 @@
+package fakecamera
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestParseExpression(t *testing.T) {
+	vars := &exprVars{n: 10, t: 2, fps: 9}
+	tests := []struct {
+		src  string
+		want float64
+	}{
+		{"42", 42},
+		{".5", 0.5},
+		{"1e3", 1000},
+		{"1E+2", 100},
+		{"2.5e-2", 0.025},
+		{"0x20", 32},
+		{"0XfF", 255},
+		{"  1 +  2  ", 3},
+
+		// precedence and associativity
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"10 - 4 - 3", 3},
+		{"8 / 4 / 2", 1},
+		{"7 % 4", 3},
+		{"1 + 7 % 4 * 2", 7},
+
+		// unary minus
+		{"-2 * 3", -6},
+		{"--2", 2},
+		{"2 * -3", -6},
+		{"-(1 + 2)", -3},
+		{"3 - -1", 4},
+
+		// variables and names, which ignore case
+		{"n", 10},
+		{"t", 2},
+		{"fps", 9},
+		{"N + T + FPS", 21},
+		{"2 * pi", 2 * math.Pi},
+		{"30 + t/60", 30 + 2.0/60},
+
+		// functions
+		{"max(28, 34 - 0.01*n)", 33.9},
+		{"min(1, 2)", 1},
+		{"Max(1, 2)", 2},
+		{"abs(-3)", 3},
+		{"floor(2.7)", 2},
+		{"sqrt(16)", 4},
+		{"sin(0)", 0},
+		{"cos(0)", 1},
+		{"max(min(1, 2), abs(-1.5))", 1.5},
+	}
+	for _, test := range tests {
+		expr, err := parseExpression(test.src)
+		if err != nil {
+			t.Errorf("parseExpression(%q) error %v", test.src, err)
+			continue
+		}
+		if got := expr(vars); math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("parseExpression(%q) = %v, want %v", test.src, got, test.want)
+		}
+	}
+}
+
+func TestParseExpressionErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"", "unexpected end"},
+		{"1 +", "unexpected end"},
+		{"-", "unexpected end"},
+		{"1 2", "unexpected"},
+		{"1e", "unexpected"},
+		{"(1 + 2", "missing )"},
+		{"1..2", "invalid number"},
+		{"0x", "invalid number"},
+		{"x", "unknown name"},
+		{"foo(1)", "unknown name"},
+		{"sin 1", "missing ("},
+		{"max(1, 2", "missing )"},
+		{"max(1)", "takes 2 arguments"},
+		{"sin(1, 2)", "takes 1 arguments"},
+	}
+	for _, test := range tests {
+		_, err := parseExpression(test.src)
+		if err == nil {
+			t.Errorf("parseExpression(%q) succeeded, want an error containing %q", test.src, test.want)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.want) {
+			t.Errorf("parseExpression(%q) error %q, want it to contain %q", test.src, err, test.want)
+		}
+	}
+}
@@ -17,174 +17,197 @@
 package fakecamera
 
 import (
-	"encoding/binary"
 	"errors"
-	"gopkg.in/yaml.v1"
 	"log"
 	"math/rand"
-	"net"
 	"net/url"
 	"strconv"
 	"sync"
 	"time"
-
-	goconfig "github.com/TheCacophonyProject/go-config"
-
-	cptvframe "github.com/TheCacophonyProject/go-cptv/cptvframe"
-	lepton3 "github.com/TheCacophonyProject/lepton3"
-	"github.com/TheCacophonyProject/thermal-recorder/headers"
 )
 
 const (
 	frameMinTemp = 3000
 	frameMaxTemp = 4000
-	sendSocket   = "/var/run/lepton-frames"
 
-	sleepTime   = 10 * time.Second
 	lockTimeout = 10 * time.Second
 )
 
 var (
-	startTime     = time.Now()
 	playCondition = sync.NewCond(&sync.Mutex{})
 	stopSending   = false
 	playing       = true
 	cptvDir       string
-	camera        cptvframe.CameraSpec
+	camera        *cameraModel
 	queue         *Queue = newQueue()
+	outputs              = newFanout()
+	frameJitter   time.Duration
 )
 
-func RunCamera(newCPTVDir, configDir string) error {
+func RunCamera(newCPTVDir string, conf *Config) error {
 	cptvDir = newCPTVDir
 	var err error
-	camera, err = getCameraSpec(configDir)
+	camera, err = parseCameraModel(conf.Camera)
 	if err != nil {
 		log.Printf("Error getting camera %v\n", err)
 		return err
 	}
+	log.Printf("Emulating %s %s %dx%d at %d fps\n", camera.Brand(), camera.Model(), camera.ResX(), camera.ResY(), camera.FPS())
 
-	for {
-		err = connectToSocket()
-		if err != nil {
-			log.Printf("Could not connect to socket %v will try again in %v\n", err, sleepTime)
-			time.Sleep(sleepTime)
-		} else {
-			log.Print("Disconnected\n")
-		}
+	if err := setConfigHeaders(conf); err != nil {
+		log.Printf("Error getting headers %v\n", err)
+		return err
 	}
-}
-
-func getCameraSpec(configDir string) (cptvframe.CameraSpec, error) {
-	configRW, err := goconfig.New(configDir)
-	if err != nil {
-		return nil, err
+	if err := setTelemetryOverrides(conf.Telemetry); err != nil {
+		log.Printf("Error getting telemetry %v\n", err)
+		return err
 	}
-	lepton := goconfig.DefaultLepton()
-	if err := configRW.Unmarshal(goconfig.LeptonKey, &lepton); err != nil {
-		return nil, err
+	setSensorConfig(conf.Sensor)
+	setFFCConfig(conf.FFC)
+	setSeed(conf.Seed)
+	frameJitter = conf.FrameJitter
+	configFaults = conf.Faults
+	if err := clock.set(conf.Clock, conf.ClockSpeed); err != nil {
+		log.Printf("Error setting clock %v\n", err)
+		return err
 	}
-	camera = &lepton3.Lepton3{}
-	return camera, nil
-}
 
-func connectToSocket() error {
-	log.Printf("dialing frame output socket %s\n", sendSocket)
-	conn, err := net.DialUnix("unix", nil, &net.UnixAddr{
-		Net:  "unix",
-		Name: sendSocket,
-	})
-	if err != nil {
-		log.Printf("error %v\n", err)
-		return errors.New("error: connecting to frame output socket failed")
+	var sinks []sink
+	captures = nil
+	for _, spec := range conf.Output {
+		s, err := parseSink(spec)
+		if err != nil {
+			log.Printf("Error getting output %v\n", err)
+			return err
+		}
+		if capture, ok := s.(*captureSink); ok {
+			captures = append(captures, capture)
+		} else {
+			sinks = append(sinks, s)
+		}
 	}
-	defer conn.Close()
-	conn.SetWriteBuffer(lepton3.FrameCols * lepton3.FrameCols * 2 * 20)
-
-	camera_specs := map[string]interface{}{
-		headers.YResolution: camera.ResY(),
-		headers.XResolution: camera.ResX(),
-		headers.FrameSize:   lepton3.BytesPerFrame,
-		headers.Model:       "lepton3.5",
-		headers.Brand:       "flir",
-		headers.FPS:         camera.FPS(),
+	if len(sinks) == 0 {
+		return errors.New("no frame outputs configured, captures only record other outputs")
+	}
+	for _, s := range sinks {
+		go runSink(s, conf.RetryInterval)
 	}
 
-	cameraYAML, _ := yaml.Marshal(camera_specs)
-	if _, err := conn.Write(cameraYAML); err != nil {
-		return err
+	if conf.StateFile != "" {
+		if err := restoreState(conf.StateFile); err != nil {
+			log.Printf("Error restoring state %v\n", err)
+		}
+		go saveState(conf.StateFile)
 	}
 
-	conn.Write([]byte("\n"))
 	log.Printf("Listening for send frames ...")
-	return queueLoop(conn)
+	queueLoop()
+	return nil
 }
 
-func Send(urlValues url.Values) {
-	p := &params{urlValues}
-
+// Send plays the frames described by urlValues and returns them as queued,
+// including the seed used to generate them
+func Send(urlValues url.Values) QueueItem {
+	p := newParams(urlValues)
 	if !p.enqueue() {
 		clearQueue(true)
 		play()
 	}
-	queue.enqueue(p)
+	return queue.enqueue(p)
 }
 
-func queueLoop(conn *net.UnixConn) error {
+// newParams sets a seed for the request if it doesn't have one, so it can be
+// repeated
+func newParams(urlValues url.Values) *params {
+	p := &params{urlValues}
+	seed, ok := p.seed()
+	if !ok {
+		seed = nextSeed()
+		p.Set("seed", strconv.FormatInt(seed, 10))
+	}
+	log.Printf("Sending frames with seed %d\n", seed)
+	return p
+}
+
+func queueLoop() {
 	for {
 		stopSending = false
-		params := queue.dequeue()
-		if params == nil {
+		item := queue.dequeue()
+		if item == nil {
 			queue.wait()
 			continue
 		}
 
-		maker, err := NewFrameMaker(params)
+		maker, err := NewFrameMaker(item.params)
 
 		if err != nil {
 			log.Printf("Error making frames %v\n", err)
+			queue.finished()
 			continue
 		}
-		err = sendFrames(conn, params, maker)
-		if err != nil {
-			return err
+		if item.resume > 0 {
+			resume(maker, item.resume)
 		}
+		clock.sync()
+		sendFrames(item.params, maker)
+		queue.finished()
 	}
 }
 
 func forceStop() {
 	stopSending = true
+	clock.wake()
 	log.Println("Stopping")
 }
 
-func Playback(params url.Values) {
+// Playback controls the frames being sent
+func Playback(params url.Values) error {
+	if raw := params.Get("speed"); raw != "" {
+		speed, err := parseSpeed(raw)
+		if err != nil {
+			return err
+		}
+		log.Printf("Playing at speed %s\n", raw)
+		setSpeed(speed)
+		clock.wake()
+	}
+	if raw := params.Get("seek"); raw != "" {
+		if err := nowPlaying.requestSeek(raw); err != nil {
+			return err
+		}
+		clock.wake()
+	}
 	stop, _ := strconv.ParseBool(params.Get("stop"))
 	clear, _ := strconv.ParseBool(params.Get("clear"))
 
 	if clear {
 		clearQueue(stop)
-		return
+		return nil
 	}
 	if stop {
 		forceStop()
-		return
+		return nil
 	}
 
 	pauseB, _ := strconv.ParseBool(params.Get("pause"))
 	if pauseB {
 		pause()
-		return
+		return nil
 	}
 	playB, _ := strconv.ParseBool(params.Get("play"))
 	if playB {
 		play()
-		return
 	}
+	return nil
 }
 
 func play() {
 	log.Println("Playing")
 	playCondition.L.Lock()
 	playing = true
+	if steps != nil {
+		steps.finish()
+	}
 	playCondition.L.Unlock()
 	playCondition.Signal()
 
@@ -195,6 +218,7 @@ func pause() {
 	playCondition.L.Lock()
 	playing = false
 	playCondition.L.Unlock()
+	clock.wake()
 }
 
 func clearQueue(stop bool) {
@@ -206,35 +230,83 @@ func clearQueue(stop bool) {
 
 }
 
-func generatePixel(minTemp, maxTemp int) uint16 {
+func generatePixel(r *rand.Rand, minTemp, maxTemp int) uint16 {
 	var pix int
 	if maxTemp <= minTemp {
 		pix = minTemp
 	} else {
-		pix = minTemp + rand.Intn(maxTemp-minTemp)
+		pix = minTemp + r.Intn(maxTemp-minTemp)
 	}
 	return uint16(pix)
 }
 
-func waitForPlay() {
+// waitForPlay blocks while paused, unless frames are being stepped. It returns
+// true if it is still paused and the next frame is stepped.
+func waitForPlay() bool {
 	playCondition.L.Lock()
-	if !playing {
+	for !playing && !stepping() {
 		playCondition.Wait()
 	}
+	step := !playing
 	playCondition.L.Unlock()
+	clock.sync()
+	return step
 }
 
-func sendFrames(conn *net.UnixConn, params *params, f *frameMaker) error {
+func sendFrames(params *params, f *frameMaker) {
 	defer f.Close()
-	// Telemetry size of 640 -64(size of telemetry words)
-	var reaminingBytes [576]byte
-	frameSleep := time.Duration(1000/f.fps) * time.Millisecond
-	for {
+	seed, _ := params.seed()
+	pace := newPacer(params.jitter(), seed)
+	speed, err := params.speed()
+	if err != nil {
+		log.Printf("Error playing frames %v\n", err)
+		return
+	}
+	setSpeed(speed)
+	faults, err := parseFaults(params)
+	if err != nil {
+		log.Printf("Error playing frames %v\n", err)
+		return
+	}
+	if faultSeed, ok := params.faultSeed(); ok {
+		seed = faultSeed
+	}
+	inject := newFaultInjector(faults, seed)
+	if raw, ok := f.frameReader.(rawFrameReader); ok {
+		sendRawFrames(raw, pace, inject)
+		return
+	}
+	sendStats.setTarget(f.fps)
+	sourceFPS := f.FPS()
+	if sourceFPS == 0 {
+		sourceFPS = f.srcFPS
+	}
+	nowPlaying.set(f.frameReader, sourceFPS)
+	defer nowPlaying.clear()
+	for n := 0; ; n++ {
+		step := false
 		if !playing {
-			waitForPlay()
+			if step = waitForPlay(); !step {
+				pace.restart()
+			}
 		}
+		outputs.waitForConsumer()
 		if stopSending {
-			return nil
+			return
+		}
+		if step {
+			pace.step(interval(n-1, f.fps))
+		} else {
+			// replicate cptv frame rate
+			pace.wait(interval(n-1, f.fps))
+			// stopping or pausing cuts the wait short
+			if stopSending {
+				return
+			}
+			if !playing {
+				waitForPlay()
+				pace.resume()
+			}
 		}
 
 		frame, err := f.NextFrame()
@@ -242,19 +314,67 @@ func sendFrames(conn *net.UnixConn, params *params, f *frameMaker) error {
 			break
 		}
 
-		buf := rawTelemetryBytes(frame.Status)
-		_ = binary.Write(buf, binary.BigEndian, reaminingBytes)
-		for _, row := range frame.Pix {
-			for x, _ := range row {
-				_ = binary.Write(buf, binary.BigEndian, row[x])
+		buf := camera.encoder.encode(frame, f.fields)
+		for _, out := range inject.apply(buf.Bytes()) {
+			outputs.write(out)
+		}
+		sendStats.frameSent(clock.now())
+		frameStepped(n, sourceFrame(f.frameReader), &frame.Status)
+		nowPlaying.played()
+	}
+	if held := inject.flush(); held != nil {
+		outputs.write(held)
+	}
+}
+
+// sendRawFrames sends already encoded frames unchanged apart from any faults,
+// with their original timing
+func sendRawFrames(r rawFrameReader, pace *pacer, inject *faultInjector) {
+	sendStats.setTarget(camera.FPS())
+	if reader, ok := r.(frameReader); ok {
+		nowPlaying.set(reader, reader.FPS())
+		defer nowPlaying.clear()
+	}
+	for n := 0; ; n++ {
+		step := false
+		if !playing {
+			if step = waitForPlay(); !step {
+				pace.restart()
 			}
 		}
-		// replicate cptv frame rate
-		if _, err := conn.Write(buf.Bytes()); err != nil {
-			// reconnect to socket
-			return err
+		outputs.waitForConsumer()
+		if stopSending {
+			return
+		}
+
+		if _, err := nowPlaying.applySeek(); err != nil {
+			return
 		}
-		time.Sleep(frameSleep)
+		frame, wait, err := r.NextRaw()
+		if err != nil {
+			break
+		}
+		if step {
+			pace.step(wait)
+		} else {
+			pace.wait(wait)
+			// stopping or pausing cuts the wait short
+			if stopSending {
+				return
+			}
+			if !playing {
+				waitForPlay()
+				pace.resume()
+			}
+		}
+		for _, out := range inject.apply(frame) {
+			outputs.write(out)
+		}
+		sendStats.frameSent(clock.now())
+		frameStepped(n, sourceFrame(r), nil)
+		nowPlaying.played()
+	}
+	if held := inject.flush(); held != nil {
+		outputs.write(held)
 	}
-	return nil
 }
@@ -1,8 +1,10 @@
 package fakecamera
 
 import (
+	"fmt"
 	"io"
 	"log"
+	"math/rand"
 	"os"
 	"path"
 	"time"
@@ -10,7 +12,6 @@ import (
 	"github.com/TheCacophonyProject/go-cptv"
 	cptvframe "github.com/TheCacophonyProject/go-cptv/cptvframe"
 	lepton3 "github.com/TheCacophonyProject/lepton3"
-
 )
 
 // simple interface so we can read from a file or generate frames seemlessly
@@ -20,43 +21,92 @@ type frameReader interface {
 	Close()
 }
 
+// repeatForever plays frames until the request is stopped
+const repeatForever = 0
+
+// finished returns if frames played the number of times in repeat are done
+func finished(played, repeat int) bool {
+	return repeat != repeatForever && played >= repeat
+}
+
 type frameMaker struct {
 	frameReader
-	hotspots []hotspot
-	fps      int
-	ffc      bool
-	lastFFC  int
+	hotspots  []hotspot
+	fps       int
+	srcFPS    int
+	ffc       bool
+	lastFFC   int
+	overrides *telemetryOverrides
+	counter   *counterEvents
+	rand      *rand.Rand
+	fields    map[string]float64
+	frame     *cptvframe.Frame
+	out       *cptvframe.Frame
+	read      int
+	made      int
 }
 
 func NewFrameMaker(p *params) (*frameMaker, error) {
+	overrides, err := parseTelemetryOverrides(p.telemetry())
+	if err != nil {
+		return nil, err
+	}
+	seed, _ := p.seed()
+	random := rand.New(rand.NewSource(seed))
+	counter, err := parseCounterEvents(p.counterGaps(), p.counterResets())
+	if err != nil {
+		return nil, err
+	}
+	if p.ffc() {
+		autoFFC.trigger()
+	}
 	var reader frameReader
 	if p.generate() {
-		reader = NewFakeReader(p)
+		reader = NewFakeReader(p, random)
+	} else if p.capture() != "" {
+		reader, err = NewCaptureReader(p)
+		if err != nil {
+			return nil, err
+		}
 	} else {
-		var err error
 		reader, err = NewCPTVReader(p)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	fps := p.fps()
+	// fps sends every source frame at that rate, otherwise frames are sent at
+	// the camera frame rate and source frames are repeated or skipped to play
+	// them at their own rate
+	fps, srcFPS := p.fps(), p.fps()
 	if fps == 0 {
-		fps = reader.FPS()
-		if fps == 0 {
-			fps = camera.FPS()
+		fps = camera.FPS()
+		srcFPS = reader.FPS()
+		if srcFPS == 0 {
+			srcFPS = fps
 		}
 	}
-	return &frameMaker{frameReader: reader, hotspots: p.hotspots(), fps: fps, ffc: p.ffc(), lastFFC: p.lastFFC()}, nil
+	return &frameMaker{
+		frameReader: reader,
+		hotspots:    p.hotspots(),
+		fps:         fps,
+		srcFPS:      srcFPS,
+		ffc:         p.ffcAlways(),
+		lastFFC:     p.lastFFC(),
+		overrides:   overrides,
+		counter:     counter,
+		rand:        random,
+		out:         cptvframe.NewFrame(camera),
+	}, nil
 }
 
-func addHotspots(pix [][]uint16, hotspots []hotspot) {
+func addHotspots(pix [][]uint16, hotspots []hotspot, r *rand.Rand) {
 	if hotspots == nil {
 		return
 	}
 
 	for _, hotspot := range hotspots {
-		hotspot.addSpot(pix)
+		hotspot.addSpot(pix, r)
 	}
 }
 
@@ -74,18 +124,78 @@ func setStatus(telemetry *cptvframe.Telemetry, timeon time.Duration, ffc bool, p
 // gets the next frame or returns an error if there are no more frames
 // also adds hotspots and makes required changes to telemetry data
 func (f *frameMaker) NextFrame() (*cptvframe.Frame, error) {
-	frame, err := f.Next()
+	seeked, err := nowPlaying.applySeek()
 	if err != nil {
 		return nil, err
 	}
+	if seeked {
+		f.frame = nil
+	}
+	want := f.made*f.srcFPS/f.fps + 1
+	// after seeking a new frame is read straight away
+	for f.read < want || f.frame == nil {
+		frame, err := f.Next()
+		if err != nil {
+			return nil, err
+		}
+		f.frame = frame
+		f.read++
+	}
+	n := f.made
+	f.made++
+	vars := &exprVars{n: float64(n), t: float64(n) / float64(f.fps), fps: float64(f.fps)}
+	// source frames can be sent more than once, so changes are made to a copy
+	frame := f.out
+	frame.Copy(f.frame)
+
+	addHotspots(frame.Pix, f.hotspots, f.rand)
+	timeOn := clock.now()
+	if !f.ffc {
+		autoFFC.update(frame, timeOn)
+	}
+	setStatus(&frame.Status, timeOn, f.ffc, 0, f.lastFFC)
+	frame.Status.FrameCount = f.counter.nextCount(n)
+	sensor.setTemps(&frame.Status)
+	f.overrides.setStatus(&frame.Status, vars)
+	sensor.shiftPixels(frame)
+	// the telemetry is worked out from the pixels as the camera sends them
+	clampPixels(frame.Pix, camera.maxPixel())
+	if !f.overrides.sets("framemean") {
+		frame.Status.FrameMean = frameMean(frame.Pix)
+	}
+	f.fields = f.overrides.rawFields(vars)
+	return frame, nil
+}
+
+// clampPixels limits pixels to max, the largest value the camera can send
+func clampPixels(pix [][]uint16, max uint16) {
+	for _, row := range pix {
+		for x, val := range row {
+			if val > max {
+				row[x] = max
+			}
+		}
+	}
+}
 
-	addHotspots(frame.Pix, f.hotspots)
-	setStatus(&frame.Status, time.Since(startTime), f.ffc, 0, f.lastFFC)
-	return frame, err
+// frameMean returns the mean of all pixels, as the camera reports it
+func frameMean(pix [][]uint16) uint16 {
+	var sum, count int
+	for _, row := range pix {
+		for _, val := range row {
+			sum += int(val)
+		}
+		count += len(row)
+	}
+	if count == 0 {
+		return 0
+	}
+	return uint16(sum / count)
 }
 
 type fakeReader struct {
 	frame     *cptvframe.Frame
+	rand      *rand.Rand
 	minTemp   int
 	maxTemp   int
 	frames    int
@@ -93,8 +203,8 @@ type fakeReader struct {
 	fps       int
 }
 
-func NewFakeReader(p *params) *fakeReader {
-	return &fakeReader{frame: cptvframe.NewFrame(camera), minTemp: p.minTemp(), maxTemp: p.maxTemp(), frames: p.repeat()}
+func NewFakeReader(p *params, r *rand.Rand) *fakeReader {
+	return &fakeReader{frame: cptvframe.NewFrame(camera), minTemp: p.minTemp(), maxTemp: p.maxTemp(), frames: p.repeat(), rand: r}
 }
 
 func (f *fakeReader) Close() {
@@ -105,7 +215,7 @@ func (f *fakeReader) FPS() int {
 }
 
 func (f *fakeReader) Next() (*cptvframe.Frame, error) {
-	if f.generated >= f.frames {
+	if finished(f.generated, f.frames) {
 		return nil, io.EOF
 	}
 	f.makeFrame()
@@ -113,10 +223,19 @@ func (f *fakeReader) Next() (*cptvframe.Frame, error) {
 	return f.frame, nil
 }
 
+func (f *fakeReader) position() int {
+	return f.generated
+}
+
+func (f *fakeReader) seek(frame int) error {
+	f.generated = frame
+	return nil
+}
+
 func (f *fakeReader) makeFrame() {
 	for y, row := range f.frame.Pix {
 		for x, _ := range row {
-			f.frame.Pix[y][x] = generatePixel(f.minTemp, f.maxTemp)
+			f.frame.Pix[y][x] = generatePixel(f.rand, f.minTemp, f.maxTemp)
 		}
 	}
 }
@@ -124,15 +243,20 @@ func (f *fakeReader) makeFrame() {
 type cptvReader struct {
 	*cptv.FileReader
 	frame    *cptvframe.Frame
+	scaled   *cptvframe.Frame
 	repeat   int
 	frameNum int
 	played   int
 	start    int
 	stop     int
 	filepath string
+	// frames sent in this play of the file
+	passFrames int
+	// seeked past the end of the file or stop
+	atEnd bool
 }
 
-func NewCPTVReader(params *params) (*cptvReader, error) {
+func NewCPTVReader(params *params) (frameReader, error) {
 	file := params.cptvFile()
 	fullpath := path.Join(cptvDir, file)
 	if _, err := os.Stat(fullpath); err != nil {
@@ -140,6 +264,13 @@ func NewCPTVReader(params *params) (*cptvReader, error) {
 		return nil, err
 	}
 
+	direction := params.direction()
+	if direction != directionForward && direction != directionReverse && direction != directionPingPong {
+		return nil, fmt.Errorf("unknown direction %q", direction)
+	}
+	if params.end() != 0 && params.end() < params.start() {
+		return nil, fmt.Errorf("end %d is before start %d", params.end(), params.start())
+	}
 	f := &cptvReader{
 		start:    params.start(),
 		stop:     params.end(),
@@ -150,22 +281,46 @@ func NewCPTVReader(params *params) (*cptvReader, error) {
 	if err != nil {
 		return nil, err
 	}
-	return f, nil
+	if direction == directionForward {
+		return f, nil
+	}
+	return newCachedReader(f, direction)
 }
 
 func (f *cptvReader) newReader() error {
+	f.passFrames = 0
+	if err := f.openFile(); err != nil {
+		return err
+	}
+	return f.readToStart()
+}
+
+func (f *cptvReader) openFile() error {
+	if f.FileReader != nil {
+		f.FileReader.Close()
+	}
 	f.frameNum = 0
+	f.atEnd = false
 	r, err := cptv.NewFileReader(f.filepath)
 	if err != nil {
 		return err
 	}
 	f.FileReader = r
 	f.frame = f.Reader.EmptyFrame()
-	return f.readToStart()
+	if f.Reader.ResX() != camera.ResX() || f.Reader.ResY() != camera.ResY() {
+		f.scaled = cptvframe.NewFrame(camera)
+	} else {
+		f.scaled = nil
+	}
+	return nil
 }
 
 func (f *cptvReader) readToStart() error {
-	for f.frameNum < f.start {
+	return f.readTo(f.start)
+}
+
+func (f *cptvReader) readTo(frame int) error {
+	for f.frameNum < frame {
 		err := f.ReadFrame(f.frame)
 		f.frameNum += 1
 		if err != nil {
@@ -176,30 +331,83 @@ func (f *cptvReader) readToStart() error {
 }
 
 func (f *cptvReader) Next() (*cptvframe.Frame, error) {
-	var err error
-	if f.stop == 0 || f.frameNum <= f.stop {
-
-		err = f.ReadFrame(f.frame)
-		f.frameNum += 1
-	} else {
-		err = io.EOF
+	frame, err := f.nextSource()
+	if err != nil || f.scaled == nil {
+		return frame, err
 	}
+	scaleFrame(frame, f.scaled)
+	return f.scaled, nil
+}
 
-	if err == io.EOF {
+// nextSource returns the next frame at the resolution of the file
+func (f *cptvReader) nextSource() (*cptvframe.Frame, error) {
+	for {
+		err := io.EOF
+		if !f.atEnd && (f.stop == 0 || f.frameNum <= f.stop) {
+			err = f.ReadFrame(f.frame)
+			f.frameNum += 1
+		}
+		if err != io.EOF {
+			if err != nil {
+				return f.frame, err
+			}
+			f.passFrames += 1
+			return f.frame, nil
+		}
 
 		f.played += 1
-		if f.played >= f.repeat {
+		// a play without any frames would repeat forever without sending any,
+		// unless it was cut short by seeking past the end
+		if finished(f.played, f.repeat) || (f.passFrames == 0 && !f.atEnd) {
 			return nil, io.EOF
-		} else {
-			//  play again
-			err := f.newReader()
-			if err != nil {
-				return nil, err
-			}
-			return f.Next()
 		}
+		//  play again
+		if err := f.newReader(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// scaleFrame resizes the pixels of src to fit dst using nearest neighbour,
+// so recordings from one camera can be played back on another
+func scaleFrame(src, dst *cptvframe.Frame) {
+	dst.Status = src.Status
+	srcRows := len(src.Pix)
+	srcCols := len(src.Pix[0])
+	dstRows := len(dst.Pix)
+	for y, row := range dst.Pix {
+		srcRow := src.Pix[y*srcRows/dstRows]
+		for x := range row {
+			row[x] = srcRow[x*srcCols/len(row)]
+		}
+	}
+}
+
+func (f *cptvReader) position() int {
+	return f.frameNum
+}
+
+// seek moves to frame of the file, going back opens the file again. Going
+// past the end finishes this play of the file.
+func (f *cptvReader) seek(frame int) error {
+	if frame < f.start {
+		frame = f.start
+	}
+	pastStop := f.stop != 0 && frame > f.stop
+	if pastStop {
+		frame = f.stop + 1
+	}
+	if frame < f.frameNum {
+		if err := f.openFile(); err != nil {
+			return err
+		}
+	}
+	err := f.readTo(frame)
+	if err == io.EOF || (err == nil && pastStop) {
+		f.atEnd = true
+		return nil
 	}
-	return f.frame, err
+	return err
 }
 
 func (f *cptvReader) fps() int {
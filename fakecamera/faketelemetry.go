@@ -3,25 +3,210 @@ package fakecamera
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 
 	cptvframe "github.com/TheCacophonyProject/go-cptv/cptvframe"
 	lepton3 "github.com/TheCacophonyProject/lepton3"
 )
 
-func rawTelemetryBytes(t cptvframe.Telemetry) *bytes.Buffer {
-	var tw telemetryWords
-	tw.TimeOn = uint32(t.TimeOn.Milliseconds())
-	tw.StatusBits = ffcStateToStatus(t.FFCState)
-	tw.FrameCounter = uint32(t.FrameCount)
-	tw.FrameMean = t.FrameMean
-	tw.FPATemp = ToK(t.TempC)
-	tw.FPATempLastFFC = ToK(t.LastFFCTempC)
-	tw.TimeCounterLastFFC = uint32(t.LastFFCTime.Milliseconds())
+const (
+	// telemetryBytes is the size of the telemetry header sent with each lepton frame
+	telemetryBytes = 640
+
+	telemetryRevision = 0x0E08 // 14.8
+	videoFormatRaw14  = 7
+	gainModeHigh      = 0
+	unityScale        = 8192 // emissivity and transmission values are scaled by this
+
+	defaultFPATempC     = 30
+	housingBelowFPAC    = 0.5
+	defaultAmbientTempC = 22
+)
+
+var (
+	telemetryLock sync.Mutex
+	// overrides of telemetry fields from the config, by field name
+	configTelemetry = map[string]float64{}
+)
+
+// leptonEncoder writes frames the same way as the lepton3 package, big endian
+// telemetry followed by big endian pixels
+type leptonEncoder struct{}
+
+func (leptonEncoder) telemetrySize(c *cameraModel) int {
+	return telemetryBytes
+}
+
+func (leptonEncoder) telemetryFields() interface{} {
+	return &leptonTelemetry{}
+}
+
+func (leptonEncoder) encode(frame *cptvframe.Frame, fields map[string]float64) *bytes.Buffer {
+	tw := leptonTelemetryWords(frame)
+	config := configTelemetryFields()
+	setTelemetryFields(tw, config)
+	setTelemetryFields(tw, fields)
+	deriveTelemetry(tw, config, fields)
 	buf := new(bytes.Buffer)
-	binary.Write(buf, lepton3.Big16, tw)
+	_ = binary.Write(buf, lepton3.Big16, tw)
+	for _, row := range frame.Pix {
+		for x, _ := range row {
+			_ = binary.Write(buf, binary.BigEndian, row[x])
+		}
+	}
 	return buf
 }
 
+// leptonTelemetryWords fills every telemetry row for frame, values that are
+// not tracked by cptvframe.Telemetry are the Lepton defaults
+func leptonTelemetryWords(frame *cptvframe.Frame) *leptonTelemetry {
+	t := frame.Status
+	fpaTempC := t.TempC
+	if fpaTempC == 0 {
+		fpaTempC = defaultFPATempC
+	}
+	lastFFCTempC := t.LastFFCTempC
+	if lastFFCTempC == 0 {
+		lastFFCTempC = fpaTempC
+	}
+
+	var tw leptonTelemetry
+	a := &tw.RowA
+	a.TelemetryRevision = telemetryRevision
+	a.TimeOn = uint32(t.TimeOn.Milliseconds())
+	a.StatusBits = ffcStateToStatus(t.FFCState)
+	a.SerialNumber = serialBytes()
+	a.SoftwareRevision = softwareRevision()
+	a.FrameCounter = uint32(t.FrameCount)
+	a.FrameMean = t.FrameMean
+	a.FPATempCounts = tempCounts(fpaTempC)
+	a.FPATemp = ToK(fpaTempC)
+	a.HousingTempCounts = tempCounts(fpaTempC - housingBelowFPAC)
+	a.HousingTemp = ToK(fpaTempC - housingBelowFPAC)
+	a.FPATempLastFFC = ToK(lastFFCTempC)
+	a.TimeCounterLastFFC = uint32(t.LastFFCTime.Milliseconds())
+	a.HousingTempLastFFC = ToK(lastFFCTempC - housingBelowFPAC)
+	a.AGCROI = [4]uint16{0, 0, uint16(camera.ResY() - 1), uint16(camera.ResX() - 1)}
+	a.AGCClipHigh = uint16(camera.ResX() * camera.ResY())
+	a.AGCClipLow = 512
+	a.VideoOutputFormat = videoFormatRaw14
+	a.Log2FFCFrames = 3
+
+	b := &tw.RowB
+	b.Emissivity = unityScale
+	b.BackgroundTemp = ToK(defaultAmbientTempC)
+	b.AtmosphericTransmission = unityScale
+	b.AtmosphericTemp = ToK(defaultAmbientTempC)
+	b.WindowTransmission = unityScale
+	b.WindowReflection = 0
+	b.WindowTemp = ToK(defaultAmbientTempC)
+	b.WindowReflectedTemp = ToK(defaultAmbientTempC)
+
+	c := &tw.RowC
+	c.GainMode = gainModeHigh
+	c.EffectiveGainMode = gainModeHigh
+	c.TempGainModeThresholdHighC = 110
+	c.TempGainModeThresholdLowC = 90
+	c.TempGainModeThresholdHighK = 383
+	c.TempGainModeThresholdLowK = 363
+	c.PopulationGainModeThresholdHigh = 25
+	c.PopulationGainModeThresholdLow = 90
+	c.GainModeROI = a.AGCROI
+	if camera.Model() == "lepton3.5" {
+		c.TLinearEnable = 1
+		c.TLinearResolution = 1
+	}
+	setSpotmeter(c, frame.Pix)
+	return &tw
+}
+
+// deriveTelemetry recalculates the sensor counts from the temperatures, as
+// these may have been overridden, unless the counts were overridden as well
+func deriveTelemetry(tw *leptonTelemetry, overrides ...map[string]float64) {
+	overridden := func(name string) bool {
+		for _, fields := range overrides {
+			for field := range fields {
+				if strings.EqualFold(field, name) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	a := &tw.RowA
+	if !overridden("FPATempCounts") {
+		a.FPATempCounts = tempCounts(a.FPATemp.ToC())
+	}
+	if !overridden("HousingTempCounts") {
+		a.HousingTempCounts = tempCounts(a.HousingTemp.ToC())
+	}
+}
+
+// setSpotmeter sets the spotmeter statistics of the default 2x2 ROI in the
+// centre of the frame
+func setSpotmeter(c *telemetryRowC, pix [][]uint16) {
+	if len(pix) < 2 || len(pix[0]) < 2 {
+		return
+	}
+	startRow, startCol := len(pix)/2-1, len(pix[0])/2-1
+	endRow, endCol := startRow+1, startCol+1
+	c.SpotmeterStartRow, c.SpotmeterStartCol = uint16(startRow), uint16(startCol)
+	c.SpotmeterEndRow, c.SpotmeterEndCol = uint16(endRow), uint16(endCol)
+
+	var sum int
+	c.SpotmeterMin = ^uint16(0)
+	for y := startRow; y <= endRow; y++ {
+		for x := startCol; x <= endCol; x++ {
+			val := pix[y][x]
+			sum += int(val)
+			if val > c.SpotmeterMax {
+				c.SpotmeterMax = val
+			}
+			if val < c.SpotmeterMin {
+				c.SpotmeterMin = val
+			}
+		}
+	}
+	c.SpotmeterPopulation = uint16((endRow - startRow + 1) * (endCol - startCol + 1))
+	c.SpotmeterMean = uint16(sum / int(c.SpotmeterPopulation))
+}
+
+// tempCounts approximates the raw sensor counts for a temperature
+func tempCounts(c float64) uint16 {
+	return uint16(8000 + c*40)
+}
+
+// serialBytes returns the camera serial from the header as it appears in the
+// telemetry
+func serialBytes() [16]byte {
+	var serial [16]byte
+	if value, ok := cameraHeader()[headerSerial]; ok {
+		if i, err := strconv.ParseUint(fmt.Sprint(value), 10, 64); err == nil {
+			binary.BigEndian.PutUint64(serial[8:], i)
+		}
+	}
+	return serial
+}
+
+// softwareRevision packs the firmware version from the header, e.g. 3.3.26,
+// into the software revision bytes
+func softwareRevision() uint64 {
+	value, ok := cameraHeader()[headerFirmware]
+	if !ok {
+		return 0
+	}
+	var rev uint64
+	for i, part := range strings.SplitN(fmt.Sprint(value), ".", 3) {
+		n, _ := strconv.ParseUint(part, 10, 8)
+		rev |= n << uint(8*i)
+	}
+	return rev
+}
+
 const statusFFCStateShift uint32 = 4
 
 func ffcStateToStatus(status string) uint32 {
@@ -44,18 +229,148 @@ func ToK(c float64) centiK {
 	return centiK(c*100 + 27315)
 }
 
-type telemetryWords struct {
-	TelemetryRevision  uint16    // 0  *
-	TimeOn             uint32    // 1  *
-	StatusBits         uint32    // 3  * Bit field
-	Reserved5          [8]uint16 // 5  *
-	SoftwareRevision   uint64    // 13 - Junk.
-	Reserved17         [3]uint16 // 17 *
-	FrameCounter       uint32    // 20 *
-	FrameMean          uint16    // 22 * The average value from the whole frame
-	FPATempCounts      uint16    // 23
-	FPATemp            centiK    // 24 *
-	Reserved25         [4]uint16 // 25
-	FPATempLastFFC     centiK    // 29
-	TimeCounterLastFFC uint32    // 30 *
+// ToC converts a Kelvin measurement to Celsius.
+func (c centiK) ToC() float64 {
+	return float64(int(c)-27315) / 100
+}
+
+// setTelemetryOverrides validates and sets the telemetry overrides from the config
+func setTelemetryOverrides(overrides map[string]interface{}) error {
+	values := map[string]float64{}
+	for name, raw := range overrides {
+		value, err := strconv.ParseFloat(fmt.Sprint(raw), 64)
+		if err != nil {
+			return fmt.Errorf("invalid value for telemetry %s %v", name, raw)
+		}
+		if err := setTelemetryField(camera.encoder.telemetryFields(), name, value); err != nil {
+			return err
+		}
+		values[name] = value
+	}
+	telemetryLock.Lock()
+	defer telemetryLock.Unlock()
+	configTelemetry = values
+	return nil
+}
+
+func configTelemetryFields() map[string]float64 {
+	telemetryLock.Lock()
+	defer telemetryLock.Unlock()
+	return configTelemetry
+}
+
+func setTelemetryFields(tw interface{}, fields map[string]float64) {
+	for name, value := range fields {
+		setTelemetryField(tw, name, value)
+	}
+}
+
+// setTelemetryField sets the field called name (ignoring case) in the rows of
+// the telemetry struct tw to value, value is in the raw units of the field
+func setTelemetryField(tw interface{}, name string, value float64) error {
+	field := findTelemetryField(reflect.ValueOf(tw).Elem(), name)
+	if !field.IsValid() {
+		return fmt.Errorf("unknown telemetry field %s", name)
+	}
+	switch field.Kind() {
+	case reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if value < 0 {
+			value = 0
+		}
+		field.SetUint(uint64(value))
+	default:
+		return fmt.Errorf("telemetry field %s can not be set", name)
+	}
+	return nil
+}
+
+func findTelemetryField(v reflect.Value, name string) reflect.Value {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			if found := findTelemetryField(field, name); found.IsValid() {
+				return found
+			}
+		} else if strings.EqualFold(v.Type().Field(i).Name, name) {
+			return field
+		}
+	}
+	return reflect.Value{}
+}
+
+// leptonTelemetry is the telemetry sent at the start of each frame, rows A, B
+// and C followed by a row of padding
+type leptonTelemetry struct {
+	RowA    telemetryRowA
+	RowB    telemetryRowB
+	RowC    telemetryRowC
+	Padding [80]uint16
+}
+
+type telemetryRowA struct {
+	TelemetryRevision  uint16     // 0  *
+	TimeOn             uint32     // 1  *
+	StatusBits         uint32     // 3  * Bit field
+	SerialNumber       [16]byte   // 5  *
+	SoftwareRevision   uint64     // 13
+	Reserved17         [3]uint16  // 17 *
+	FrameCounter       uint32     // 20 *
+	FrameMean          uint16     // 22 * The average value from the whole frame
+	FPATempCounts      uint16     // 23
+	FPATemp            centiK     // 24 *
+	HousingTempCounts  uint16     // 25
+	HousingTemp        centiK     // 26
+	Reserved27         [2]uint16  // 27
+	FPATempLastFFC     centiK     // 29
+	TimeCounterLastFFC uint32     // 30 *
+	HousingTempLastFFC centiK     // 32
+	Reserved33         uint16     // 33
+	AGCROI             [4]uint16  // 34 top, left, bottom, right
+	AGCClipHigh        uint16     // 38
+	AGCClipLow         uint16     // 39
+	Reserved40         [32]uint16 // 40
+	VideoOutputFormat  uint32     // 72
+	Log2FFCFrames      uint16     // 74
+	Reserved75         [5]uint16  // 75
+}
+
+type telemetryRowB struct {
+	Reserved0               [19]uint16 // 0
+	Emissivity              uint16     // 19 scaled by 8192
+	BackgroundTemp          centiK     // 20
+	AtmosphericTransmission uint16     // 21 scaled by 8192
+	AtmosphericTemp         centiK     // 22
+	WindowTransmission      uint16     // 23 scaled by 8192
+	WindowReflection        uint16     // 24 scaled by 8192
+	WindowTemp              centiK     // 25
+	WindowReflectedTemp     centiK     // 26
+	Reserved27              [53]uint16 // 27
+}
+
+type telemetryRowC struct {
+	Reserved0                       [5]uint16  // 0
+	GainMode                        uint16     // 5  0 high, 1 low, 2 auto
+	EffectiveGainMode               uint16     // 6
+	GainModeDesiredFlag             uint16     // 7
+	TempGainModeThresholdHighC      uint16     // 8
+	TempGainModeThresholdLowC       uint16     // 9
+	TempGainModeThresholdHighK      uint16     // 10
+	TempGainModeThresholdLowK       uint16     // 11
+	Reserved12                      [2]uint16  // 12
+	PopulationGainModeThresholdHigh uint16     // 14
+	PopulationGainModeThresholdLow  uint16     // 15
+	Reserved16                      [6]uint16  // 16
+	GainModeROI                     [4]uint16  // 22 top, left, bottom, right
+	Reserved26                      [12]uint16 // 26
+	TLinearEnable                   uint16     // 38
+	TLinearResolution               uint16     // 39 0 is 0.1K, 1 is 0.01K
+	SpotmeterMean                   uint16     // 40
+	SpotmeterMax                    uint16     // 41
+	SpotmeterMin                    uint16     // 42
+	SpotmeterPopulation             uint16     // 43
+	SpotmeterStartRow               uint16     // 44
+	SpotmeterStartCol               uint16     // 45
+	SpotmeterEndRow                 uint16     // 46
+	SpotmeterEndCol                 uint16     // 47
+	Reserved48                      [32]uint16 // 48
 }
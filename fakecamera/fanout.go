@@ -0,0 +1,191 @@
+package fakecamera
+
+import (
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v1"
+)
+
+const (
+	// number of frames buffered for each consumer, frames are dropped for a
+	// consumer that falls further behind than this
+	consumerBuffer = 20
+)
+
+// fanout sends every frame to all connected consumers
+type fanout struct {
+	mu        sync.Mutex
+	consumers map[*consumer]bool
+	connected *sync.Cond
+}
+
+func newFanout() *fanout {
+	f := &fanout{consumers: make(map[*consumer]bool)}
+	f.connected = sync.NewCond(&f.mu)
+	return f
+}
+
+func (f *fanout) add(c *consumer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.consumers[c] = true
+	f.connected.Broadcast()
+}
+
+func (f *fanout) remove(c *consumer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.consumers, c)
+}
+
+// waitForConsumer blocks until at least one consumer is connected
+func (f *fanout) waitForConsumer() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for len(f.consumers) == 0 {
+		f.connected.Wait()
+	}
+}
+
+// write queues frame for every consumer. With a real time clock it never
+// blocks on a slow consumer, otherwise or when sending at unlimited speed it
+// waits for every consumer so that no frames are dropped.
+func (f *fanout) write(frame []byte) {
+	wait := !clock.realTime() || currentSpeed() == unlimitedSpeed
+	f.mu.Lock()
+	consumers := make([]*consumer, 0, len(f.consumers))
+	for c := range f.consumers {
+		consumers = append(consumers, c)
+	}
+	f.mu.Unlock()
+	for _, c := range consumers {
+		if wait {
+			c.sendWait(frame)
+		} else {
+			c.send(frame)
+		}
+	}
+}
+
+// reconnect disconnects all consumers so they reconnect with the new header
+func (f *fanout) reconnect() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for c := range f.consumers {
+		c.stop()
+	}
+}
+
+// consumer is a single connection to a sink
+type consumer struct {
+	name    string
+	w       io.WriteCloser
+	frames  chan []byte
+	quit    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+	dropped int
+}
+
+func newConsumer(name string, w io.WriteCloser) *consumer {
+	return &consumer{
+		name:   name,
+		w:      w,
+		frames: make(chan []byte, consumerBuffer),
+		quit:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+func (c *consumer) send(frame []byte) {
+	select {
+	case c.frames <- frame:
+		if c.dropped > 0 {
+			log.Printf("%s caught up after dropping %d frames\n", c.name, c.dropped)
+			c.dropped = 0
+		}
+	default:
+		if c.dropped == 0 {
+			log.Printf("%s is too slow, dropping frames\n", c.name)
+		}
+		c.dropped++
+	}
+}
+
+// sendWait queues frame, waiting for space unless the consumer has stopped
+func (c *consumer) sendWait(frame []byte) {
+	select {
+	case c.frames <- frame:
+	case <-c.quit:
+	case <-c.done:
+	}
+}
+
+func (c *consumer) stop() {
+	c.once.Do(func() { close(c.quit) })
+}
+
+// serve writes the header then frames until the connection fails or the
+// consumer is stopped
+func (c *consumer) serve() error {
+	defer close(c.done)
+	defer c.w.Close()
+	cameraYAML, _ := yaml.Marshal(cameraHeader())
+	// the header is sent in a single write so sinks can tell it from frames
+	if _, err := c.w.Write(append(cameraYAML, '\n')); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-c.quit:
+			return errReconnect
+		case frame := <-c.frames:
+			if _, err := c.w.Write(frame); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// runSink connects consumers to s and serves them frames from outputs until
+// the program exits
+func runSink(s sink, retryInterval time.Duration) {
+	for {
+		if s.listening() {
+			log.Printf("waiting for connections on frame output %v\n", s)
+		} else {
+			log.Printf("dialing frame output %v\n", s)
+		}
+		w, err := s.connect()
+		if err != nil {
+			log.Printf("Could not connect to %v %v will try again in %v\n", s, err, retryInterval)
+			time.Sleep(retryInterval)
+			continue
+		}
+
+		c := newConsumer(s.String(), recorded(w, s.String()))
+		if s.listening() {
+			go serveConsumer(c)
+			continue
+		}
+		if err := serveConsumer(c); err != errReconnect {
+			time.Sleep(retryInterval)
+		}
+	}
+}
+
+func serveConsumer(c *consumer) error {
+	log.Printf("Connected to %s\n", c.name)
+	outputs.add(c)
+	err := c.serve()
+	outputs.remove(c)
+	if err == errReconnect {
+		log.Printf("Reconnecting to %s\n", c.name)
+	} else {
+		log.Printf("Disconnected from %s %v\n", c.name, err)
+	}
+	return err
+}
@@ -0,0 +1,120 @@
+package fakecamera
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+)
+
+// configFaults are added to every request that doesn't set its own
+var configFaults FaultConfig
+
+// parseFaults reads the faults of a request, starting from the configured
+// faults
+func parseFaults(p *params) (FaultConfig, error) {
+	faults := configFaults
+	chances := map[string]*float64{
+		"drop":            &faults.Drop,
+		"duplicate":       &faults.Duplicate,
+		"freeze":          &faults.Freeze,
+		"pixel-flips":     &faults.PixelFlips,
+		"telemetry-flips": &faults.TelemetryFlips,
+		"truncate":        &faults.Truncate,
+		"shuffle":         &faults.Shuffle,
+	}
+	var err error
+	for key, value := range chances {
+		if raw := p.Get(key); raw != "" {
+			if *value, err = strconv.ParseFloat(raw, 64); err != nil || *value < 0 || *value > 1 {
+				return faults, fmt.Errorf("invalid %s %q, expected a chance from 0 to 1", key, raw)
+			}
+		}
+	}
+	if raw := p.Get("freeze-frames"); raw != "" {
+		if faults.FreezeFrames, err = strconv.Atoi(raw); err != nil || faults.FreezeFrames < 0 {
+			return faults, fmt.Errorf("invalid freeze-frames %q", raw)
+		}
+	}
+	return faults, nil
+}
+
+// faultInjector adds faults to encoded frames
+type faultInjector struct {
+	FaultConfig
+	rand          *rand.Rand
+	telemetrySize int
+	frozen        []byte
+	frozenLeft    int
+	held          []byte
+}
+
+func newFaultInjector(faults FaultConfig, seed int64) *faultInjector {
+	if faults.FreezeFrames == 0 {
+		faults.FreezeFrames = camera.FPS()
+	}
+	return &faultInjector{
+		FaultConfig:   faults,
+		rand:          rand.New(rand.NewSource(seed)),
+		telemetrySize: camera.encoder.telemetrySize(camera),
+	}
+}
+
+// apply returns the frames to send in place of frame, which may be none
+func (f *faultInjector) apply(frame []byte) [][]byte {
+	if f.frozenLeft > 0 {
+		f.frozenLeft--
+		return [][]byte{f.frozen}
+	}
+	if f.chance(f.Freeze) {
+		f.frozen = append([]byte(nil), frame...)
+		f.frozenLeft = f.FreezeFrames - 1
+	} else if f.chance(f.Drop) {
+		return nil
+	}
+
+	// a frame shorter than the telemetry only has telemetry to flip
+	telemetrySize := f.telemetrySize
+	if telemetrySize > len(frame) {
+		telemetrySize = len(frame)
+	}
+	if f.chance(f.PixelFlips) {
+		f.flipBit(frame[telemetrySize:])
+	}
+	if f.chance(f.TelemetryFlips) {
+		f.flipBit(frame[:telemetrySize])
+	}
+	if f.chance(f.Truncate) && len(frame) > 0 {
+		frame = frame[:f.rand.Intn(len(frame))]
+	}
+	frames := [][]byte{frame}
+	if f.chance(f.Duplicate) {
+		frames = append(frames, frame)
+	}
+	if f.held != nil {
+		frames = append(frames, f.held)
+		f.held = nil
+	} else if f.chance(f.Shuffle) {
+		f.held = frames[0]
+		frames = frames[1:]
+	}
+	return frames
+}
+
+// flush returns the frame held back to be shuffled, if there is one
+func (f *faultInjector) flush() []byte {
+	held := f.held
+	f.held = nil
+	return held
+}
+
+func (f *faultInjector) chance(chance float64) bool {
+	return chance > 0 && f.rand.Float64() < chance
+}
+
+func (f *faultInjector) flipBit(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	bit := f.rand.Intn(len(data) * 8)
+	data[bit/8] ^= 1 << uint(bit%8)
+}
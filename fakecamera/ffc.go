@@ -0,0 +1,120 @@
+package fakecamera
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	cptvframe "github.com/TheCacophonyProject/go-cptv/cptvframe"
+	lepton3 "github.com/TheCacophonyProject/lepton3"
+)
+
+// what is sent while the shutter is closed
+const (
+	shutterFrozen = "frozen"
+	shutterFlat   = "flat"
+)
+
+// ffcModel runs flat field corrections like a Lepton does automatically. An
+// FFC is due after an interval or when the FPA temperature has changed enough
+// since the last one, the status then goes from imminent to running to
+// complete. While running the shutter is closed so the frames don't change.
+type ffcModel struct {
+	mu         sync.Mutex
+	conf       FFCConfig
+	state      string
+	lastFFC    time.Duration
+	imminentAt time.Duration
+	runningAt  time.Duration
+	requested  bool
+	shutter    *cptvframe.Frame
+}
+
+// the camera has run an FFC when it booted
+var autoFFC = &ffcModel{conf: defaultFFCConfig(), state: lepton3.FFCComplete}
+
+func setFFCConfig(conf FFCConfig) {
+	autoFFC.mu.Lock()
+	defer autoFFC.mu.Unlock()
+	autoFFC.conf = conf
+}
+
+// trigger starts an FFC on the next frame, even if automatic FFC is off
+func (m *ffcModel) trigger() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requested = true
+}
+
+// due returns if an automatic FFC should start now
+func (m *ffcModel) due(timeOn time.Duration) bool {
+	if !m.conf.Auto {
+		return false
+	}
+	if m.conf.Interval > 0 && timeOn-m.lastFFC >= m.conf.Interval {
+		return true
+	}
+	tempDelta := sensor.fpaTempAt(timeOn) - sensor.fpaTempAt(m.lastFFC)
+	return m.conf.TempDelta > 0 && math.Abs(tempDelta) >= m.conf.TempDelta
+}
+
+// update moves the FFC state on to timeOn and sets the status of frame.
+// Frames sent while the shutter is closed are replaced by the shutter frame.
+func (m *ffcModel) update(frame *cptvframe.Frame, timeOn time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.conf.Auto && !m.requested && m.state != lepton3.FFCImminent && m.state != lepton3.FFCRunning {
+		return
+	}
+
+	switch m.state {
+	case lepton3.FFCImminent:
+		if timeOn-m.imminentAt >= m.conf.Imminent {
+			m.state = lepton3.FFCRunning
+			m.runningAt = timeOn
+			m.lastFFC = timeOn
+			m.closeShutter(frame)
+		}
+	case lepton3.FFCRunning:
+		if timeOn-m.runningAt >= m.conf.Duration {
+			m.state = lepton3.FFCComplete
+		}
+	default:
+		if m.requested || m.due(timeOn) {
+			m.requested = false
+			m.state = lepton3.FFCImminent
+			m.imminentAt = timeOn
+		}
+	}
+
+	if m.state == lepton3.FFCRunning {
+		copyPix(frame.Pix, m.shutter.Pix)
+	}
+	frame.Status.FFCState = m.state
+	frame.Status.LastFFCTime = m.lastFFC
+}
+
+// closeShutter saves what is sent until the FFC completes, the last frame
+// before the shutter closed or its mean
+func (m *ffcModel) closeShutter(frame *cptvframe.Frame) {
+	if m.shutter == nil || len(m.shutter.Pix) != len(frame.Pix) {
+		m.shutter = frame.CreateCopy()
+	} else {
+		copyPix(m.shutter.Pix, frame.Pix)
+	}
+	if m.conf.ShutterFrames != shutterFlat {
+		return
+	}
+	mean := frameMean(m.shutter.Pix)
+	for _, row := range m.shutter.Pix {
+		for x := range row {
+			row[x] = mean
+		}
+	}
+}
+
+func copyPix(dst, src [][]uint16) {
+	for y, row := range src {
+		copy(dst[y], row)
+	}
+}
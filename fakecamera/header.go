@@ -0,0 +1,184 @@
+package fakecamera
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/TheCacophonyProject/thermal-recorder/headers"
+	"gopkg.in/yaml.v1"
+)
+
+// header fields not in the vendored thermal-recorder headers package
+const (
+	headerSerial   = "CameraSerial"
+	headerFirmware = "Firmware"
+)
+
+var knownHeaders = []string{
+	headers.XResolution,
+	headers.YResolution,
+	headers.FPS,
+	headers.Model,
+	headers.Brand,
+	headers.PixelBits,
+	headers.FrameSize,
+	headerSerial,
+	headerFirmware,
+}
+
+var (
+	headerLock     sync.Mutex
+	configHeaders  = map[string]interface{}{}
+	runtimeHeaders = map[string]interface{}{}
+)
+
+var errReconnect = errors.New("camera header changed, reconnecting")
+
+// headerKey returns the canonical name of a header field, config keys are
+// lower case so these are matched without case
+func headerKey(key string) string {
+	for _, known := range knownHeaders {
+		if strings.EqualFold(known, key) {
+			return known
+		}
+	}
+	return key
+}
+
+// headerValue keeps numbers as ints so thermal-recorder can read them, apart
+// from the serial and firmware which are kept as sent e.g. "0123"
+func headerValue(key, value string) interface{} {
+	if key == headerSerial || key == headerFirmware {
+		return value
+	}
+	if i, err := strconv.Atoi(value); err == nil {
+		return i
+	}
+	return value
+}
+
+// framingValue returns the value of a header field the frames are encoded
+// with, these can't be changed while the camera is running
+func framingValue(key string) (int, bool) {
+	switch key {
+	case headers.XResolution:
+		return camera.ResX(), true
+	case headers.YResolution:
+		return camera.ResY(), true
+	case headers.FrameSize:
+		return camera.FrameSize(), true
+	case headers.FPS:
+		return camera.FPS(), true
+	}
+	return 0, false
+}
+
+// checkFraming returns an error if value changes a header field the frames
+// are encoded with
+func checkFraming(key, value string) error {
+	if want, ok := framingValue(key); ok && value != strconv.Itoa(want) {
+		return fmt.Errorf("%s is %d for this camera, the frames can't be sent as %s", key, want, value)
+	}
+	return nil
+}
+
+func setConfigHeaders(conf *Config) error {
+	headerLock.Lock()
+	defer headerLock.Unlock()
+	configHeaders = map[string]interface{}{}
+	for key, value := range conf.Headers {
+		key = headerKey(key)
+		if err := checkFraming(key, fmt.Sprint(value)); err != nil {
+			return err
+		}
+		if key == headerSerial || key == headerFirmware {
+			value = fmt.Sprint(value)
+		}
+		configHeaders[key] = value
+	}
+	if conf.Serial != "" {
+		configHeaders[headerSerial] = conf.Serial
+	}
+	if conf.Firmware != "" {
+		configHeaders[headerFirmware] = conf.Firmware
+	}
+	return nil
+}
+
+// cameraHeader returns the header fields sent when connecting to the frame
+// socket, fields set by config or SetHeaderFields override those of the camera
+func cameraHeader() map[string]interface{} {
+	header := map[string]interface{}{
+		headers.YResolution: camera.ResY(),
+		headers.XResolution: camera.ResX(),
+		headers.FrameSize:   camera.FrameSize(),
+		headers.Model:       camera.Model(),
+		headers.Brand:       camera.Brand(),
+		headers.FPS:         camera.FPS(),
+	}
+	headerLock.Lock()
+	defer headerLock.Unlock()
+	for key, value := range configHeaders {
+		header[key] = value
+	}
+	for key, value := range runtimeHeaders {
+		header[key] = value
+	}
+	return header
+}
+
+// HeaderYAML returns the header that is sent when connecting to the frame socket
+func HeaderYAML() ([]byte, error) {
+	if camera == nil {
+		return nil, errors.New("camera is not running")
+	}
+	return yaml.Marshal(cameraHeader())
+}
+
+// SetHeaderFields sets the header fields in values, an empty value removes the
+// field. If reset is true all fields previously set are removed. Any change
+// makes every consumer reconnect with the new header. The resolution, frame
+// size and frame rate can only be set to those of the camera.
+func SetHeaderFields(values url.Values) error {
+	if camera == nil {
+		return errors.New("camera is not running")
+	}
+	for key := range values {
+		if value := values.Get(key); value != "" {
+			if err := checkFraming(headerKey(key), value); err != nil {
+				return err
+			}
+		}
+	}
+
+	headerLock.Lock()
+	changed := false
+	if reset, _ := strconv.ParseBool(values.Get("reset")); reset {
+		runtimeHeaders = map[string]interface{}{}
+		changed = true
+	}
+	for key := range values {
+		if key == "reset" {
+			continue
+		}
+		value := values.Get(key)
+		if value == "" {
+			delete(runtimeHeaders, headerKey(key))
+		} else {
+			runtimeHeaders[headerKey(key)] = headerValue(headerKey(key), value)
+		}
+		changed = true
+	}
+	headerLock.Unlock()
+
+	if changed {
+		log.Println("Camera header changed")
+		outputs.reconnect()
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package fakecamera
 import (
     "encoding/json"
     "math"
+    "math/rand"
 )
 
 type shape interface {
@@ -43,7 +44,7 @@ type hotspot struct {
     shape shape
 }
 
-func (h hotspot) addSpot(pix [][]uint16) {
+func (h hotspot) addSpot(pix [][]uint16, r *rand.Rand) {
     height := len(pix)
     width := len(pix[0])
     yStart := int(math.Max(float64(h.spot.Y), 0))
@@ -51,7 +52,7 @@ func (h hotspot) addSpot(pix [][]uint16) {
         start, stop := h.shape.intersections(h.spot, i)
         start = int(math.Max(float64(start), 0))
         for z := start; z <= stop && z < width; z++ {
-            pix[i][z] = generatePixel(h.spot.MinTemp, h.spot.MaxTemp)
+            pix[i][z] = generatePixel(r, h.spot.MinTemp, h.spot.MaxTemp)
         }
     }
 }
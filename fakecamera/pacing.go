@@ -0,0 +1,199 @@
+package fakecamera
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// number of frames the achieved frame rate is measured over
+	fpsWindow = 32
+
+	// frames are sent as fast as consumers read them
+	unlimitedSpeed = 0
+)
+
+var (
+	speedLock sync.Mutex
+	// how many times faster than the frame rate frames are being sent
+	playSpeed float64 = 1
+)
+
+// parseSpeed reads a playback speed, a multiplier such as 0.25 or 10, or
+// "unlimited"
+func parseSpeed(raw string) (float64, error) {
+	if raw == "unlimited" {
+		return unlimitedSpeed, nil
+	}
+	speed, err := strconv.ParseFloat(strings.TrimSuffix(raw, "x"), 64)
+	if err != nil || speed <= 0 {
+		return 0, fmt.Errorf("invalid speed %q", raw)
+	}
+	return speed, nil
+}
+
+func setSpeed(speed float64) {
+	speedLock.Lock()
+	defer speedLock.Unlock()
+	playSpeed = speed
+}
+
+func currentSpeed() float64 {
+	speedLock.Lock()
+	defer speedLock.Unlock()
+	return playSpeed
+}
+
+// pacer sends frames at deadlines on the camera clock, rather than sleeping
+// between frames, so time spent making and writing frames doesn't add up and
+// the frame rate holds over long runs
+type pacer struct {
+	next    time.Duration
+	started bool
+	jitter  time.Duration
+	rand    *rand.Rand
+}
+
+func newPacer(jitter time.Duration, seed int64) *pacer {
+	return &pacer{jitter: jitter, rand: rand.New(rand.NewSource(seed))}
+}
+
+// interval returns the time between frame n and n+1 at fps, these add up to
+// exactly a second every fps frames
+func interval(n, fps int) time.Duration {
+	return time.Duration(n+1)*time.Second/time.Duration(fps) - time.Duration(n)*time.Second/time.Duration(fps)
+}
+
+// wait sleeps until the next frame is due, which is after the previous
+// frame's deadline. If sending has fallen more than a frame behind, such as
+// after being paused, the deadlines start again from now instead of sending
+// frames in a burst to catch up.
+func (p *pacer) wait(after time.Duration) {
+	speed := currentSpeed()
+	if speed == unlimitedSpeed {
+		// camera time still moves on as if the frames were sent in time
+		p.step(after)
+		return
+	}
+	after = time.Duration(float64(after) / speed)
+	now := clock.now()
+	if !p.started {
+		p.started = true
+		p.next = now
+	} else {
+		p.next += after
+		if now-p.next > after {
+			p.next = now
+		}
+	}
+
+	deadline := p.next
+	if p.jitter > 0 {
+		deadline += time.Duration(p.rand.Int63n(int64(2*p.jitter))) - p.jitter
+	}
+	if d := deadline - now; d > 0 && !clock.sleep(d) {
+		// playback changed part way through the wait, the frames after this
+		// one are paced from now
+		p.next = clock.now()
+	}
+}
+
+// step sends the next frame straight away, such as one stepped while paused.
+// The camera clock moves on to when it would have been due, unless it is
+// already past that.
+func (p *pacer) step(after time.Duration) {
+	now := clock.now()
+	p.next += after
+	if !p.started || now > p.next {
+		p.next = now
+	}
+	p.started = true
+	clock.skipTo(p.next)
+}
+
+// restart makes the next frame due straight away
+func (p *pacer) restart() {
+	p.started = false
+}
+
+// resume makes the frame about to be sent due now, the frames after it are
+// paced from it
+func (p *pacer) resume() {
+	p.started = true
+	p.next = clock.now()
+}
+
+// frameStats measures the frames sent by the camera
+type frameStats struct {
+	mu        sync.Mutex
+	targetFPS int
+	sent      int
+	times     [fpsWindow]time.Duration
+}
+
+var sendStats = &frameStats{}
+
+func (s *frameStats) setTarget(fps int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.targetFPS = fps
+}
+
+// frameSent records a frame sent at camera time t
+func (s *frameStats) frameSent(t time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.times[s.sent%fpsWindow] = t
+	s.sent++
+}
+
+// achievedFPS returns the frame rate over the last frames sent, in camera time
+func (s *frameStats) achievedFPS() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	frames := s.sent
+	if frames > fpsWindow {
+		frames = fpsWindow
+	}
+	if frames < 2 {
+		return 0
+	}
+	last := s.times[(s.sent-1)%fpsWindow]
+	first := s.times[(s.sent-frames)%fpsWindow]
+	if last <= first {
+		return 0
+	}
+	return float64(frames-1) / (last - first).Seconds()
+}
+
+// Status describes what the camera is sending
+type Status struct {
+	Playing     bool    `json:"playing"`
+	Speed       string  `json:"speed"`
+	TargetFPS   int     `json:"targetFPS"`
+	AchievedFPS float64 `json:"achievedFPS"`
+	FramesSent  int     `json:"framesSent"`
+	Clock       string  `json:"clock"`
+}
+
+// CameraStatus returns the status of the camera
+func CameraStatus() Status {
+	playCondition.L.Lock()
+	isPlaying := playing
+	playCondition.L.Unlock()
+
+	sendStats.mu.Lock()
+	status := Status{Playing: isPlaying, TargetFPS: sendStats.targetFPS, FramesSent: sendStats.sent}
+	sendStats.mu.Unlock()
+	status.AchievedFPS = sendStats.achievedFPS()
+	status.Speed = "unlimited"
+	if speed := currentSpeed(); speed != unlimitedSpeed {
+		status.Speed = strconv.FormatFloat(speed, 'f', -1, 64)
+	}
+	status.Clock = clock.status().Time
+	return status
+}
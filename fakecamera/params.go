@@ -5,6 +5,7 @@ import (
 	"log"
 	"net/url"
 	"strconv"
+	"time"
 )
 
 type params struct {
@@ -15,6 +16,68 @@ func (p *params) cptvFile() string {
 	return p.Get("cptv-file")
 }
 
+func (p *params) telemetry() string {
+	return p.Get("telemetry")
+}
+
+func (p *params) counterGaps() string {
+	return p.Get("counter-gaps")
+}
+
+func (p *params) counterResets() string {
+	return p.Get("counter-resets")
+}
+
+// seed returns the seed for generating frames, and if one was set
+func (p *params) seed() (int64, bool) {
+	value, err := strconv.ParseInt(p.Get("seed"), 10, 64)
+	return value, err == nil
+}
+
+// faultSeed returns the seed for the faults added to frames, and if one was
+// set
+func (p *params) faultSeed() (int64, bool) {
+	value, err := strconv.ParseInt(p.Get("fault-seed"), 10, 64)
+	return value, err == nil
+}
+
+// jitter returns how far each frame is sent from when it is due
+func (p *params) jitter() time.Duration {
+	value, err := time.ParseDuration(p.Get("jitter"))
+	if err != nil || value < 0 {
+		return frameJitter
+	}
+	return value
+}
+
+// speed returns how many times faster than the frame rate to send frames
+func (p *params) speed() (float64, error) {
+	if p.Get("speed") == "" {
+		return 1, nil
+	}
+	return parseSpeed(p.Get("speed"))
+}
+
+func (p *params) at() string {
+	return p.Get("at")
+}
+
+func (p *params) delay() string {
+	return p.Get("delay")
+}
+
+func (p *params) every() string {
+	return p.Get("every")
+}
+
+func (p *params) between() string {
+	return p.Get("between")
+}
+
+func (p *params) capture() string {
+	return p.Get("capture")
+}
+
 func (p *params) minTemp() int {
 	value, _ := strconv.Atoi(p.Get("minTemp"))
 	return value
@@ -30,6 +93,11 @@ func (p *params) ffc() bool {
 	return value
 }
 
+// ffcAlways returns if every frame should be sent as running an FFC
+func (p *params) ffcAlways() bool {
+	return p.Get("ffc") == "always"
+}
+
 func (p *params) fps() int {
 	value, _ := strconv.Atoi(p.Get("fps"))
 	return value
@@ -60,7 +128,11 @@ func (p *params) hotspots() []hotspot {
 	return hotspots
 }
 
+// repeat returns how many times to play the frames, or repeatForever
 func (p *params) repeat() int {
+	if p.Get("repeat") == "forever" {
+		return repeatForever
+	}
 	value, _ := strconv.Atoi(p.Get("repeat"))
 	if 1 > value {
 		return 1
@@ -68,6 +140,14 @@ func (p *params) repeat() int {
 	return value
 }
 
+// direction returns which way CPTV files are played
+func (p *params) direction() string {
+	if p.Get("direction") == "" {
+		return directionForward
+	}
+	return p.Get("direction")
+}
+
 func (p *params) generate() bool {
 	value, _ := strconv.ParseBool(p.Get("generate"))
 	return value
@@ -1,6 +1,9 @@
 package fakecamera
 
 import (
+	"fmt"
+	"log"
+	"net/url"
 	"sync"
 )
 
@@ -8,14 +11,29 @@ const (
 	capacity = 3
 )
 
+type queueItem struct {
+	id     int
+	params *params
+	// frame of the source to start at, when carrying on after a restart
+	resume int
+}
+
 type Queue struct {
-	values   []*params
+	values   []*queueItem
+	current  *queueItem
+	nextID   int
 	pending  bool
 	waitCond *sync.Cond
 }
 
+// QueueItem describes a request waiting to be sent
+type QueueItem struct {
+	ID     int               `json:"id"`
+	Params map[string]string `json:"params"`
+}
+
 func newQueue() *Queue {
-	return &Queue{values: make([]*params, 0, capacity), waitCond: sync.NewCond(&sync.Mutex{})}
+	return &Queue{values: make([]*queueItem, 0, capacity), nextID: 1, waitCond: sync.NewCond(&sync.Mutex{})}
 }
 
 func (q *Queue) lock() {
@@ -26,16 +44,42 @@ func (q *Queue) unlock() {
 	q.waitCond.L.Unlock()
 }
 
-func (q *Queue) enqueue(params *params) {
-	q.lock()
-	defer q.unlock()
-	q.values = append(q.values, params)
+// newItem gives params the next ID, the queue must be locked
+func (q *Queue) newItem(params *params) *queueItem {
+	item := &queueItem{id: q.nextID, params: params}
+	q.nextID++
+	return item
+}
+
+// added wakes the queue loop if it is waiting, the queue must be locked
+func (q *Queue) added() {
 	if q.pending {
 		q.waitCond.Signal()
 	}
 }
 
-func (q *Queue) dequeue() *params {
+// find returns the position of the item with id, the queue must be locked
+func (q *Queue) find(id int) (int, error) {
+	for i, item := range q.values {
+		if item.id == id {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("no queue item %d", id)
+}
+
+func (q *Queue) enqueue(params *params) QueueItem {
+	q.lock()
+	defer q.unlock()
+	item := q.newItem(params)
+	q.values = append(q.values, item)
+	q.added()
+	return item.export()
+}
+
+// dequeue takes the next request off the queue, it is the current request
+// until finished is called
+func (q *Queue) dequeue() *queueItem {
 	q.lock()
 	defer q.unlock()
 	if len(q.values) == 0 {
@@ -44,13 +88,20 @@ func (q *Queue) dequeue() *params {
 	}
 	top := q.values[0]
 	q.values = q.values[1:]
+	q.current = top
 	return top
 }
 
+func (q *Queue) finished() {
+	q.lock()
+	defer q.unlock()
+	q.current = nil
+}
+
 func (q *Queue) clear() {
 	q.lock()
 	defer q.unlock()
-	q.values = make([]*params, 0, capacity)
+	q.values = make([]*queueItem, 0, capacity)
 }
 
 func (q *Queue) wait() {
@@ -62,3 +113,134 @@ func (q *Queue) wait() {
 	q.waitCond.Wait()
 
 }
+
+// items returns everything waiting in the queue, in the order it will be sent
+func (q *Queue) items() []QueueItem {
+	q.lock()
+	defer q.unlock()
+	items := make([]QueueItem, len(q.values))
+	for i, item := range q.values {
+		items[i] = item.export()
+	}
+	return items
+}
+
+func (q *Queue) remove(id int) error {
+	q.lock()
+	defer q.unlock()
+	i, err := q.find(id)
+	if err != nil {
+		return err
+	}
+	q.values = append(q.values[:i], q.values[i+1:]...)
+	return nil
+}
+
+// move puts the item with id at position, counting from 0
+func (q *Queue) move(id, position int) error {
+	q.lock()
+	defer q.unlock()
+	i, err := q.find(id)
+	if err != nil {
+		return err
+	}
+	item := q.values[i]
+	q.values = append(q.values[:i], q.values[i+1:]...)
+	q.insertAt(item, position)
+	return nil
+}
+
+// insert adds params at position, counting from 0, or at the end if position
+// is past it
+func (q *Queue) insert(params *params, position int) QueueItem {
+	q.lock()
+	defer q.unlock()
+	item := q.newItem(params)
+	q.insertAt(item, position)
+	q.added()
+	return item.export()
+}
+
+func (q *Queue) insertAt(item *queueItem, position int) {
+	if position < 0 {
+		position = 0
+	}
+	if position > len(q.values) {
+		position = len(q.values)
+	}
+	q.values = append(q.values, nil)
+	copy(q.values[position+1:], q.values[position:])
+	q.values[position] = item
+}
+
+// replace swaps everything in the queue for all of params at once
+func (q *Queue) replace(all []*params) []QueueItem {
+	q.lock()
+	defer q.unlock()
+	q.values = make([]*queueItem, 0, len(all))
+	items := make([]QueueItem, len(all))
+	for i, params := range all {
+		item := q.newItem(params)
+		q.values = append(q.values, item)
+		items[i] = item.export()
+	}
+	if len(all) > 0 {
+		q.added()
+	}
+	return items
+}
+
+func (item *queueItem) export() QueueItem {
+	values := make(map[string]string, len(item.params.Values))
+	for key := range item.params.Values {
+		values[key] = item.params.Get(key)
+	}
+	return QueueItem{ID: item.id, Params: values}
+}
+
+// QueueItems returns the requests waiting to be sent
+func QueueItems() []QueueItem {
+	return queue.items()
+}
+
+// RemoveFromQueue removes the request with id from the queue
+func RemoveFromQueue(id int) error {
+	if err := queue.remove(id); err != nil {
+		return err
+	}
+	log.Printf("Removed %d from the queue\n", id)
+	return nil
+}
+
+// MoveInQueue moves the request with id to position in the queue
+func MoveInQueue(id, position int) error {
+	if err := queue.move(id, position); err != nil {
+		return err
+	}
+	log.Printf("Moved %d to %d in the queue\n", id, position)
+	return nil
+}
+
+// InsertInQueue adds the request described by urlValues at position in the
+// queue, or at the end if position is past it
+func InsertInQueue(urlValues url.Values, position int) QueueItem {
+	item := queue.insert(newParams(urlValues), position)
+	log.Printf("Inserted %d at %d in the queue\n", item.ID, position)
+	return item
+}
+
+// ReplaceQueue swaps the whole queue for the requests described by all
+func ReplaceQueue(all []url.Values) []QueueItem {
+	params := make([]*params, len(all))
+	for i, urlValues := range all {
+		params[i] = newParams(urlValues)
+	}
+	log.Printf("Replaced the queue with %d requests\n", len(params))
+	return queue.replace(params)
+}
+
+// ClearQueue removes every request from the queue, and stops the one being
+// sent if stop is set
+func ClearQueue(stop bool) {
+	clearQueue(stop)
+}
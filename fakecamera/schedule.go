@@ -0,0 +1,294 @@
+package fakecamera
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// query parameters that schedule a request instead of sending it
+var scheduleParams = []string{"at", "delay", "every", "between"}
+
+// scheduledJob sends a request at a time of day, and again every so often
+// while inside a daily window
+type scheduledJob struct {
+	id     int
+	values url.Values
+	next   time.Time
+	every  time.Duration
+	window *dailyWindow
+	fired  int
+	timer  *time.Timer
+}
+
+// ScheduledJob describes a request waiting to be sent at a time
+type ScheduledJob struct {
+	ID      int               `json:"id"`
+	Params  map[string]string `json:"params"`
+	Next    time.Time         `json:"next"`
+	Every   string            `json:"every,omitempty"`
+	Between string            `json:"between,omitempty"`
+	Fired   int               `json:"fired"`
+}
+
+// dailyWindow is a time of day range e.g. 09:00-17:00, it can cross midnight
+type dailyWindow struct {
+	from time.Duration
+	to   time.Duration
+}
+
+func parseWindow(raw string) (*dailyWindow, error) {
+	parts := strings.Split(raw, "-")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid between %q, expected e.g. 09:00-17:00", raw)
+	}
+	from, err := parseTimeOfDay(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	to, err := parseTimeOfDay(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	return &dailyWindow{from: from, to: to}, nil
+}
+
+// parseTimeOfDay reads HH:MM or HH:MM:SS as the time since midnight
+func parseTimeOfDay(raw string) (time.Duration, error) {
+	for _, layout := range []string{"15:04", "15:04:05"} {
+		if t, err := time.Parse(layout, raw); err == nil {
+			return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid time of day %q", raw)
+}
+
+func midnight(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+func (w *dailyWindow) contains(t time.Time) bool {
+	day := t.Sub(midnight(t))
+	if w.from <= w.to {
+		return day >= w.from && day < w.to
+	}
+	return day >= w.from || day < w.to
+}
+
+// first returns t if it is inside the window, otherwise when the window next
+// opens
+func (w *dailyWindow) first(t time.Time) time.Time {
+	if w.contains(t) {
+		return t
+	}
+	start := midnight(t).Add(w.from)
+	if start.Before(t) {
+		start = start.AddDate(0, 0, 1)
+	}
+	return start
+}
+
+func (w *dailyWindow) String() string {
+	return fmt.Sprintf("%s-%s", timeOfDay(w.from), timeOfDay(w.to))
+}
+
+func timeOfDay(d time.Duration) string {
+	return midnight(time.Now()).Add(d).Format("15:04:05")
+}
+
+// parseStart reads when a job first runs, from the at and delay parameters
+func parseStart(at, delay string, now time.Time) (time.Time, error) {
+	start := now
+	if at != "" {
+		if t, err := time.Parse(time.RFC3339, at); err == nil {
+			start = t
+		} else if day, err := parseTimeOfDay(at); err == nil {
+			start = midnight(now).Add(day)
+			if start.Before(now) {
+				start = start.AddDate(0, 0, 1)
+			}
+		} else {
+			return start, fmt.Errorf("invalid at %q, expected e.g. 09:30 or 2020-06-01T09:30:00Z", at)
+		}
+	}
+	if delay != "" {
+		d, err := time.ParseDuration(delay)
+		if err != nil || d < 0 {
+			return start, fmt.Errorf("invalid delay %q", delay)
+		}
+		start = start.Add(d)
+	}
+	return start, nil
+}
+
+// scheduler holds the jobs waiting to send requests
+type scheduler struct {
+	mu     sync.Mutex
+	jobs   map[int]*scheduledJob
+	nextID int
+}
+
+var schedule = &scheduler{jobs: make(map[int]*scheduledJob), nextID: 1}
+
+// add starts the timer of job, giving it an ID if it doesn't have one
+func (s *scheduler) add(job *scheduledJob) ScheduledJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if job.id == 0 {
+		job.id = s.nextID
+	}
+	if job.id >= s.nextID {
+		s.nextID = job.id + 1
+	}
+	s.jobs[job.id] = job
+	job.timer = time.AfterFunc(time.Until(job.next), func() { s.fire(job) })
+	return job.export()
+}
+
+// fire sends the request of job, then sets it to run again or removes it
+func (s *scheduler) fire(job *scheduledJob) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.jobs[job.id] != job {
+		// cancelled
+		return
+	}
+	values := url.Values{}
+	for key, value := range job.values {
+		values[key] = append([]string(nil), value...)
+	}
+	log.Printf("Scheduled request %d sending\n", job.id)
+	Send(values)
+	job.fired++
+
+	if job.every == 0 {
+		delete(s.jobs, job.id)
+		return
+	}
+	job.advance(time.Now())
+	job.timer.Reset(time.Until(job.next))
+}
+
+// advance moves job to its next run after now, inside its window. Missed runs,
+// such as while the computer was asleep, aren't caught up.
+func (job *scheduledJob) advance(now time.Time) {
+	for !job.next.After(now) {
+		job.next = job.next.Add(job.every)
+	}
+	if job.window != nil {
+		job.next = job.window.first(job.next)
+	}
+}
+
+func (s *scheduler) cancel(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return fmt.Errorf("no scheduled request %d", id)
+	}
+	job.timer.Stop()
+	delete(s.jobs, id)
+	return nil
+}
+
+// list returns the jobs in the order they will next run
+func (s *scheduler) list() []ScheduledJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jobs := make([]ScheduledJob, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, job.export())
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		if jobs[i].Next.Equal(jobs[j].Next) {
+			return jobs[i].ID < jobs[j].ID
+		}
+		return jobs[i].Next.Before(jobs[j].Next)
+	})
+	return jobs
+}
+
+func (job *scheduledJob) export() ScheduledJob {
+	values := make(map[string]string, len(job.values))
+	for key := range job.values {
+		values[key] = job.values.Get(key)
+	}
+	exported := ScheduledJob{ID: job.id, Params: values, Next: job.next, Fired: job.fired}
+	if job.every > 0 {
+		exported.Every = job.every.String()
+	}
+	if job.window != nil {
+		exported.Between = job.window.String()
+	}
+	return exported
+}
+
+// newJob makes a job from the values of a request, the schedule parameters
+// are taken out of values
+func newJob(values url.Values, now time.Time) (*scheduledJob, error) {
+	p := &params{values}
+	job := &scheduledJob{}
+	next, err := parseStart(p.at(), p.delay(), now)
+	if err != nil {
+		return nil, err
+	}
+	if raw := p.every(); raw != "" {
+		if job.every, err = time.ParseDuration(raw); err != nil || job.every <= 0 {
+			return nil, fmt.Errorf("invalid every %q", raw)
+		}
+	}
+	if raw := p.between(); raw != "" {
+		if job.window, err = parseWindow(raw); err != nil {
+			return nil, err
+		}
+		next = job.window.first(next)
+	}
+	for _, name := range scheduleParams {
+		values.Del(name)
+	}
+	job.values = values
+	job.next = next
+	return job, nil
+}
+
+// Scheduled returns if urlValues asks for a request to be sent later
+func Scheduled(urlValues url.Values) bool {
+	for _, name := range scheduleParams {
+		if urlValues.Get(name) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Schedule sends the request described by urlValues at the time given by its
+// at and delay parameters, and again every so often if it has an every
+// parameter, only between the times of day of its between parameter
+func Schedule(urlValues url.Values) (ScheduledJob, error) {
+	job, err := newJob(urlValues, time.Now())
+	if err != nil {
+		return ScheduledJob{}, err
+	}
+	scheduled := schedule.add(job)
+	log.Printf("Scheduled request %d for %s\n", scheduled.ID, scheduled.Next.Format(time.RFC3339))
+	return scheduled, nil
+}
+
+// ScheduledJobs returns the requests waiting to be sent later
+func ScheduledJobs() []ScheduledJob {
+	return schedule.list()
+}
+
+// CancelScheduled stops the scheduled request with id being sent
+func CancelScheduled(id int) error {
+	if err := schedule.cancel(id); err != nil {
+		return err
+	}
+	log.Printf("Cancelled scheduled request %d\n", id)
+	return nil
+}
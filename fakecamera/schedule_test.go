@@ -0,0 +1,113 @@
+package fakecamera
+
+import (
+	"testing"
+	"time"
+)
+
+func at(hour, min int) time.Time {
+	return time.Date(2020, 6, 1, hour, min, 0, 0, time.UTC)
+}
+
+func TestDailyWindow(t *testing.T) {
+	day, err := parseWindow("09:00-17:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	night, err := parseWindow("22:00-06:30")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		window    *dailyWindow
+		t         time.Time
+		contains  bool
+		wantFirst time.Time
+	}{
+		{day, at(8, 59), false, at(9, 0)},
+		{day, at(9, 0), true, at(9, 0)},
+		{day, at(12, 0), true, at(12, 0)},
+		{day, at(17, 0), false, at(9, 0).AddDate(0, 0, 1)},
+		{day, at(23, 0), false, at(9, 0).AddDate(0, 0, 1)},
+
+		// crossing midnight
+		{night, at(21, 59), false, at(22, 0)},
+		{night, at(22, 0), true, at(22, 0)},
+		{night, at(23, 59), true, at(23, 59)},
+		{night, at(0, 0), true, at(0, 0)},
+		{night, at(6, 29), true, at(6, 29)},
+		{night, at(6, 30), false, at(22, 0)},
+		{night, at(12, 0), false, at(22, 0)},
+	}
+	for _, test := range tests {
+		if got := test.window.contains(test.t); got != test.contains {
+			t.Errorf("%v contains %v = %v, want %v", test.window, test.t.Format("15:04"), got, test.contains)
+		}
+		if got := test.window.first(test.t); !got.Equal(test.wantFirst) {
+			t.Errorf("%v first after %v = %v, want %v", test.window, test.t.Format("15:04"), got, test.wantFirst)
+		}
+	}
+}
+
+func TestParseWindowErrors(t *testing.T) {
+	for _, raw := range []string{"", "09:00", "09:00-", "9-17", "09:00-25:00", "09:00-17:00-18:00"} {
+		if _, err := parseWindow(raw); err == nil {
+			t.Errorf("parseWindow(%q) succeeded", raw)
+		}
+	}
+}
+
+func TestParseStart(t *testing.T) {
+	now := at(10, 0)
+	tests := []struct {
+		at, delay string
+		want      time.Time
+	}{
+		{"", "", now},
+		{"", "90s", now.Add(90 * time.Second)},
+		{"11:30", "", at(11, 30)},
+		{"09:30", "", at(9, 30).AddDate(0, 0, 1)},
+		{"09:30", "1h", at(10, 30).AddDate(0, 0, 1)},
+		{"2020-06-02T08:00:00Z", "", time.Date(2020, 6, 2, 8, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		got, err := parseStart(test.at, test.delay, now)
+		if err != nil {
+			t.Errorf("parseStart(%q, %q) error %v", test.at, test.delay, err)
+			continue
+		}
+		if !got.Equal(test.want) {
+			t.Errorf("parseStart(%q, %q) = %v, want %v", test.at, test.delay, got, test.want)
+		}
+	}
+	for _, bad := range [][2]string{{"tomorrow", ""}, {"", "soon"}, {"", "-1m"}} {
+		if _, err := parseStart(bad[0], bad[1], now); err == nil {
+			t.Errorf("parseStart(%q, %q) succeeded", bad[0], bad[1])
+		}
+	}
+}
+
+func TestJobAdvance(t *testing.T) {
+	night, _ := parseWindow("22:00-06:00")
+	tests := []struct {
+		name   string
+		next   time.Time
+		every  time.Duration
+		window *dailyWindow
+		now    time.Time
+		want   time.Time
+	}{
+		{"on time", at(10, 0), time.Hour, nil, at(10, 0), at(11, 0)},
+		{"missed runs aren't caught up", at(10, 0), time.Hour, nil, at(13, 30), at(14, 0)},
+		{"runs stay on their times", at(10, 0), 15 * time.Minute, nil, at(10, 50), at(11, 0)},
+		{"waits for the window", at(5, 0), 30 * time.Minute, night, at(5, 59), at(22, 0)},
+		{"inside the window", at(23, 0), 30 * time.Minute, night, at(23, 0), at(23, 30)},
+	}
+	for _, test := range tests {
+		job := &scheduledJob{next: test.next, every: test.every, window: test.window}
+		job.advance(test.now)
+		if !job.next.Equal(test.want) {
+			t.Errorf("%s: next is %v, want %v", test.name, job.next, test.want)
+		}
+	}
+}
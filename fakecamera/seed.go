@@ -0,0 +1,32 @@
+package fakecamera
+
+import (
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var (
+	seedLock sync.Mutex
+	// seeds makes the seed of each request that doesn't set one, so a run of
+	// the server can be repeated from its seed
+	seeds = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// setSeed seeds the requests of the server, 0 uses a random seed
+func setSeed(seed int64) {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	log.Printf("Server seed %d\n", seed)
+	seedLock.Lock()
+	defer seedLock.Unlock()
+	seeds = rand.New(rand.NewSource(seed))
+}
+
+func nextSeed() int64 {
+	seedLock.Lock()
+	defer seedLock.Unlock()
+	return seeds.Int63()
+}
@@ -0,0 +1,143 @@
+package fakecamera
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// seeker is implemented by sources that can move to any frame
+type seeker interface {
+	// position returns the number of the next frame, from the start of the
+	// source
+	position() int
+	seek(frame int) error
+}
+
+// seekRequest is a frame or time to move to, either from the start of the
+// source or from the current frame
+type seekRequest struct {
+	relative bool
+	frames   int
+	offset   time.Duration
+	isTime   bool
+}
+
+// parseSeek reads a frame number e.g. 120, a time e.g. 1m30s, or either
+// prefixed by + or - to move relative to the current frame
+func parseSeek(raw string) (*seekRequest, error) {
+	s := &seekRequest{}
+	value := raw
+	if strings.HasPrefix(value, "+") || strings.HasPrefix(value, "-") {
+		s.relative = true
+	}
+	if frames, err := strconv.Atoi(value); err == nil {
+		s.frames = frames
+		return s, nil
+	}
+	offset, err := time.ParseDuration(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid seek %q", raw)
+	}
+	s.isTime = true
+	s.offset = offset
+	return s, nil
+}
+
+// target returns the frame to seek to from the frame at pos
+func (s *seekRequest) target(pos, fps int) int {
+	frames := s.frames
+	if s.isTime {
+		frames = int(math.Round(s.offset.Seconds() * float64(fps)))
+	}
+	if s.relative {
+		frames += pos
+	}
+	if frames < 0 {
+		return 0
+	}
+	return frames
+}
+
+// playingSource is the source of the frames being sent, so playback can be
+// controlled while it is playing
+type playingSource struct {
+	mu      sync.Mutex
+	reader  frameReader
+	fps     int
+	pending *seekRequest
+	frame   int
+}
+
+var nowPlaying = &playingSource{}
+
+func (s *playingSource) set(reader frameReader, fps int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reader = reader
+	s.fps = fps
+	s.pending = nil
+	s.frame = 0
+	if src, ok := reader.(seeker); ok {
+		s.frame = src.position()
+	}
+}
+
+func (s *playingSource) clear() {
+	s.set(nil, 0)
+}
+
+// requestSeek moves the source on the next frame
+func (s *playingSource) requestSeek(raw string) error {
+	req, err := parseSeek(raw)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.reader == nil {
+		return errors.New("nothing is playing")
+	}
+	if _, ok := s.reader.(seeker); !ok {
+		return errors.New("the playing source can not seek")
+	}
+	s.pending = req
+	return nil
+}
+
+// applySeek moves the source if a seek has been requested, it returns if it
+// moved
+func (s *playingSource) applySeek() (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pending == nil {
+		return false, nil
+	}
+	src := s.reader.(seeker)
+	target := s.pending.target(src.position(), s.fps)
+	s.pending = nil
+	log.Printf("Seeking to frame %d\n", target)
+	return true, src.seek(target)
+}
+
+// played records the frame the source is up to, this is called by the sender
+// after each frame so it can be read while the source is in use
+func (s *playingSource) played() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if src, ok := s.reader.(seeker); ok {
+		s.frame = src.position()
+	}
+}
+
+// position returns the frame the source is up to, and if anything is playing
+func (s *playingSource) position() (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.frame, s.reader != nil
+}
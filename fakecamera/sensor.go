@@ -0,0 +1,95 @@
+package fakecamera
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	cptvframe "github.com/TheCacophonyProject/go-cptv/cptvframe"
+)
+
+// sensorModel is a simple thermal model of the camera core. From boot the FPA
+// warms up from the ambient temperature towards ambient plus its self heating,
+// while the ambient temperature drifts slowly up and down. Uncorrected pixel
+// offsets drift with the FPA temperature until the next FFC.
+type sensorModel struct {
+	mu             sync.Mutex
+	conf           SensorConfig
+	lastFFCTime    time.Duration
+	lastFFCTempC   float64
+	lastFFCUpdated bool
+}
+
+var sensor = newSensorModel(defaultSensorConfig())
+
+func newSensorModel(conf SensorConfig) *sensorModel {
+	return &sensorModel{conf: conf}
+}
+
+func setSensorConfig(conf SensorConfig) {
+	sensor.mu.Lock()
+	defer sensor.mu.Unlock()
+	sensor.conf = conf
+	sensor.lastFFCUpdated = false
+}
+
+// ambientTemp returns the ambient temperature timeOn after boot
+func (s *sensorModel) ambientTemp(timeOn time.Duration) float64 {
+	temp := s.conf.Ambient
+	if s.conf.DriftPeriod > 0 {
+		temp += s.conf.Drift * math.Sin(2*math.Pi*timeOn.Seconds()/s.conf.DriftPeriod.Seconds())
+	}
+	return temp
+}
+
+// fpaTemp returns the FPA temperature timeOn after boot
+func (s *sensorModel) fpaTemp(timeOn time.Duration) float64 {
+	warm := 1.0
+	if s.conf.WarmUp > 0 {
+		warm = 1 - math.Exp(-timeOn.Seconds()/s.conf.WarmUp.Seconds())
+	}
+	return s.ambientTemp(timeOn) + s.conf.SelfHeating*warm
+}
+
+func (s *sensorModel) fpaTempAt(timeOn time.Duration) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fpaTemp(timeOn)
+}
+
+// setTemps sets the FPA temperatures of the telemetry from the model, the
+// last FFC temperature is reset whenever the last FFC time changes
+func (s *sensorModel) setTemps(t *cptvframe.Telemetry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.lastFFCUpdated || t.LastFFCTime != s.lastFFCTime {
+		s.lastFFCTime = t.LastFFCTime
+		s.lastFFCTempC = s.fpaTemp(t.LastFFCTime)
+		s.lastFFCUpdated = true
+	}
+	t.TempC = s.fpaTemp(t.TimeOn)
+	t.LastFFCTempC = s.lastFFCTempC
+}
+
+// shiftPixels offsets the pixels by how far the FPA temperature has drifted
+// since the last FFC
+func (s *sensorModel) shiftPixels(frame *cptvframe.Frame) {
+	s.mu.Lock()
+	pixelDrift := s.conf.PixelDrift
+	s.mu.Unlock()
+	offset := int(math.Round((frame.Status.TempC - frame.Status.LastFFCTempC) * pixelDrift))
+	if offset == 0 {
+		return
+	}
+	for _, row := range frame.Pix {
+		for x, val := range row {
+			shifted := int(val) + offset
+			if shifted < 0 {
+				shifted = 0
+			} else if shifted > math.MaxUint16 {
+				shifted = math.MaxUint16
+			}
+			row[x] = uint16(shifted)
+		}
+	}
+}
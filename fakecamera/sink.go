@@ -0,0 +1,220 @@
+package fakecamera
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// sink is a destination for frames. Each consumer connected to a sink is sent
+// the camera header followed by every frame.
+type sink interface {
+	String() string
+	// connect blocks until a consumer is available
+	connect() (io.WriteCloser, error)
+	// listening sinks can have any number of consumers connected at once
+	listening() bool
+}
+
+// parseSink reads a sink of the form
+//
+//	unix:///path/to/socket   dial a unix socket
+//	tcp://host:port          dial a TCP address
+//	pipe:///path/to/fifo     write to a named pipe, it is created if missing
+//	file:///path/to/file     write to a file
+//	stdout:                  write to standard output
+//	capture:///path/to/dir   record what the other outputs are sent, so it
+//	                         can be replayed
+//
+// Adding ?listen=true to a unix or tcp sink makes the camera listen on that
+// address instead. A plain path is treated as a unix socket. Adding
+// ?format=vospi to any sink sends the Lepton packet stream instead of frames.
+func parseSink(spec string) (sink, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	s, err := parseBaseSink(spec, u)
+	if err != nil {
+		return nil, err
+	}
+	switch format := u.Query().Get("format"); format {
+	case "", "frames":
+		return s, nil
+	case "vospi":
+		if _, ok := s.(*captureSink); ok {
+			return nil, fmt.Errorf("captures record the frames of the other outputs, remove the format from %q", spec)
+		}
+		options, err := parseVospiOptions(u.Query())
+		if err != nil {
+			return nil, err
+		}
+		return &vospiSink{sink: s, options: options}, nil
+	default:
+		return nil, fmt.Errorf("unsupported format %q in output %q", format, spec)
+	}
+}
+
+func parseBaseSink(spec string, u *url.URL) (sink, error) {
+	var err error
+	switch u.Scheme {
+	case "stdout":
+		return stdoutSink{}, nil
+	case "pipe", "file", "capture":
+		if u.Path == "" {
+			return nil, fmt.Errorf("no path in output %q", spec)
+		}
+		switch u.Scheme {
+		case "pipe":
+			return &pipeSink{path: u.Path}, nil
+		case "capture":
+			return &captureSink{dir: u.Path}, nil
+		}
+		return &fileSink{path: u.Path}, nil
+	}
+
+	s := &socketSink{network: u.Scheme}
+	switch u.Scheme {
+	case "", "unix":
+		s.network = "unix"
+		s.address = u.Path
+	case "tcp":
+		s.address = u.Host
+	default:
+		return nil, fmt.Errorf("unsupported output %q", spec)
+	}
+	if s.address == "" {
+		return nil, fmt.Errorf("no address in output %q", spec)
+	}
+	if listen := u.Query().Get("listen"); listen != "" {
+		if s.listen, err = strconv.ParseBool(listen); err != nil {
+			return nil, fmt.Errorf("invalid listen in output %q", spec)
+		}
+	}
+	return s, nil
+}
+
+// socketSink dials the consumer, like the real camera service does, or listens
+// for consumers to connect
+type socketSink struct {
+	network  string
+	address  string
+	listen   bool
+	listener net.Listener
+}
+
+func (s *socketSink) String() string {
+	if s.listen {
+		return fmt.Sprintf("%s listener %s", s.network, s.address)
+	}
+	return fmt.Sprintf("%s socket %s", s.network, s.address)
+}
+
+func (s *socketSink) listening() bool {
+	return s.listen
+}
+
+func (s *socketSink) connect() (io.WriteCloser, error) {
+	conn, err := s.dialOrAccept()
+	if err != nil {
+		return nil, err
+	}
+	if bufConn, ok := conn.(interface{ SetWriteBuffer(int) error }); ok {
+		bufConn.SetWriteBuffer(camera.FrameSize() * 20)
+	}
+	return conn, nil
+}
+
+func (s *socketSink) dialOrAccept() (net.Conn, error) {
+	if !s.listen {
+		return net.Dial(s.network, s.address)
+	}
+	if s.listener == nil {
+		if s.network == "unix" {
+			// remove a socket left over from a previous run
+			os.Remove(s.address)
+		}
+		listener, err := net.Listen(s.network, s.address)
+		if err != nil {
+			return nil, err
+		}
+		s.listener = listener
+	}
+	return s.listener.Accept()
+}
+
+// pipeSink writes to a named pipe, connect blocks until a reader opens it
+type pipeSink struct {
+	path string
+}
+
+func (s *pipeSink) String() string {
+	return "named pipe " + s.path
+}
+
+func (s *pipeSink) listening() bool {
+	return false
+}
+
+func (s *pipeSink) connect() (io.WriteCloser, error) {
+	if _, err := os.Stat(s.path); os.IsNotExist(err) {
+		if err := syscall.Mkfifo(s.path, 0666); err != nil {
+			return nil, err
+		}
+	}
+	return os.OpenFile(s.path, os.O_WRONLY, 0)
+}
+
+// fileSink writes to a file, it is truncated when first opened and appended
+// to after that
+type fileSink struct {
+	path   string
+	opened bool
+}
+
+func (s *fileSink) String() string {
+	return "file " + s.path
+}
+
+func (s *fileSink) listening() bool {
+	return false
+}
+
+func (s *fileSink) connect() (io.WriteCloser, error) {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if !s.opened {
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(s.path, flags, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s.opened = true
+	return f, nil
+}
+
+type stdoutSink struct{}
+
+func (stdoutSink) String() string {
+	return "stdout"
+}
+
+func (stdoutSink) listening() bool {
+	return false
+}
+
+func (stdoutSink) connect() (io.WriteCloser, error) {
+	return nopCloser{os.Stdout}, nil
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
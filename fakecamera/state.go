@@ -0,0 +1,162 @@
+package fakecamera
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"sort"
+	"time"
+)
+
+// how often the state file is written, if anything has changed
+const stateInterval = time.Second
+
+type savedItem struct {
+	ID     int        `json:"id"`
+	Params url.Values `json:"params"`
+	Frame  int        `json:"frame,omitempty"`
+}
+
+type savedJob struct {
+	ID      int        `json:"id"`
+	Params  url.Values `json:"params"`
+	Next    time.Time  `json:"next"`
+	Every   string     `json:"every,omitempty"`
+	Between string     `json:"between,omitempty"`
+	Fired   int        `json:"fired,omitempty"`
+}
+
+// savedState is kept in the state file, so the queue and playback carry on
+// when the server is restarted
+type savedState struct {
+	NextID  int         `json:"nextID"`
+	Playing bool        `json:"playing"`
+	Current *savedItem  `json:"current,omitempty"`
+	Queue   []savedItem `json:"queue"`
+	Jobs    []savedJob  `json:"jobs,omitempty"`
+}
+
+func currentState() savedState {
+	playCondition.L.Lock()
+	state := savedState{Playing: playing}
+	playCondition.L.Unlock()
+
+	// the queue is unlocked before the schedule is locked, as scheduled
+	// requests lock the queue when they are sent
+	queue.lock()
+	state.NextID = queue.nextID
+	if item := queue.current; item != nil {
+		frame, ok := nowPlaying.position()
+		if !ok {
+			// it hasn't started playing yet
+			frame = item.resume
+		}
+		state.Current = &savedItem{ID: item.id, Params: item.params.Values, Frame: frame}
+	}
+	state.Queue = make([]savedItem, len(queue.values))
+	for i, item := range queue.values {
+		state.Queue[i] = savedItem{ID: item.id, Params: item.params.Values}
+	}
+	queue.unlock()
+
+	schedule.mu.Lock()
+	defer schedule.mu.Unlock()
+	for _, job := range schedule.jobs {
+		exported := job.export()
+		state.Jobs = append(state.Jobs, savedJob{ID: job.id, Params: job.values, Next: job.next, Every: exported.Every, Between: exported.Between, Fired: job.fired})
+	}
+	sort.Slice(state.Jobs, func(i, j int) bool { return state.Jobs[i].ID < state.Jobs[j].ID })
+	return state
+}
+
+// saveState writes the state to path whenever it changes
+func saveState(path string) {
+	var last []byte
+	for range time.Tick(stateInterval) {
+		raw, err := json.MarshalIndent(currentState(), "", "  ")
+		if err != nil {
+			log.Printf("Could not save state %v\n", err)
+			continue
+		}
+		if bytes.Equal(raw, last) {
+			continue
+		}
+		// written to a temporary file first so a restart never sees half a file
+		if err := ioutil.WriteFile(path+".tmp", raw, 0644); err != nil {
+			log.Printf("Could not save state %v\n", err)
+			continue
+		}
+		if err := os.Rename(path+".tmp", path); err != nil {
+			log.Printf("Could not save state %v\n", err)
+			continue
+		}
+		last = raw
+	}
+}
+
+// restoreState loads the queue and playback from the state file at path, the
+// request that was playing is put back at the front of the queue to carry on
+// from the frame it was up to
+func restoreState(path string) error {
+	raw, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var state savedState
+	if err := json.Unmarshal(raw, &state); err != nil {
+		return err
+	}
+
+	queue.lock()
+	if state.NextID > queue.nextID {
+		queue.nextID = state.NextID
+	}
+	if state.Current != nil {
+		queue.values = append(queue.values, &queueItem{id: state.Current.ID, params: &params{state.Current.Params}, resume: state.Current.Frame})
+	}
+	for _, item := range state.Queue {
+		queue.values = append(queue.values, &queueItem{id: item.ID, params: &params{item.Params}})
+	}
+	restored := len(queue.values)
+	queue.unlock()
+
+	playCondition.L.Lock()
+	playing = state.Playing
+	playCondition.L.Unlock()
+
+	// scheduled requests due while the server was down are sent straight away
+	for _, saved := range state.Jobs {
+		job := &scheduledJob{id: saved.ID, values: saved.Params, next: saved.Next, fired: saved.Fired}
+		if saved.Every != "" {
+			if job.every, err = time.ParseDuration(saved.Every); err != nil {
+				return err
+			}
+		}
+		if saved.Between != "" {
+			if job.window, err = parseWindow(saved.Between); err != nil {
+				return err
+			}
+		}
+		schedule.add(job)
+	}
+	log.Printf("Restored %d requests and %d scheduled requests from %s\n", restored, len(state.Jobs), path)
+	return nil
+}
+
+// resume moves the source of maker on to frame
+func resume(maker *frameMaker, frame int) {
+	src, ok := maker.frameReader.(seeker)
+	if !ok {
+		return
+	}
+	log.Printf("Resuming at frame %d\n", frame)
+	if err := src.seek(frame); err != nil {
+		log.Printf("Could not resume %v\n", err)
+	}
+}
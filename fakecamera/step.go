@@ -0,0 +1,135 @@
+package fakecamera
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"time"
+
+	cptvframe "github.com/TheCacophonyProject/go-cptv/cptvframe"
+)
+
+// SteppedFrame describes a frame sent while stepping
+type SteppedFrame struct {
+	// frame of the request, counting from 0
+	Frame int `json:"frame"`
+	// frame of the file, capture or generated frames, -1 if it isn't known
+	SourceFrame int               `json:"sourceFrame"`
+	Telemetry   *SteppedTelemetry `json:"telemetry,omitempty"`
+}
+
+// SteppedTelemetry is the telemetry sent with a frame, times are in
+// milliseconds
+type SteppedTelemetry struct {
+	TimeOn       int64   `json:"timeOn"`
+	FFCState     string  `json:"ffcState"`
+	FrameCount   int     `json:"frameCount"`
+	FrameMean    uint16  `json:"frameMean"`
+	TempC        float64 `json:"tempC"`
+	LastFFCTempC float64 `json:"lastFFCTempC"`
+	LastFFCTime  int64   `json:"lastFFCTime"`
+}
+
+// stepper lets a number of frames be sent while paused
+type stepper struct {
+	remaining int
+	frames    []SteppedFrame
+	done      chan struct{}
+}
+
+// steps is guarded by playCondition.L
+var steps *stepper
+
+// finish ends the step, playCondition.L must be held
+func (s *stepper) finish() {
+	close(s.done)
+	if steps == s {
+		steps = nil
+	}
+}
+
+// Step sends the number of frames in the step value while paused, then
+// pauses again. It waits for the frames to be sent and returns them.
+func Step(values url.Values) ([]SteppedFrame, error) {
+	n, err := strconv.Atoi(values.Get("step"))
+	if err != nil || n <= 0 {
+		return nil, fmt.Errorf("invalid step %q", values.Get("step"))
+	}
+	s := &stepper{remaining: n, done: make(chan struct{})}
+	playCondition.L.Lock()
+	if playing {
+		playCondition.L.Unlock()
+		return nil, errors.New("can only step while paused")
+	}
+	if steps != nil {
+		playCondition.L.Unlock()
+		return nil, errors.New("already stepping")
+	}
+	steps = s
+	playCondition.L.Unlock()
+	playCondition.Signal()
+	log.Printf("Stepping %d frames\n", n)
+
+	timeout := time.NewTimer(lockTimeout)
+	defer timeout.Stop()
+	select {
+	case <-s.done:
+	case <-timeout.C:
+	}
+	playCondition.L.Lock()
+	defer playCondition.L.Unlock()
+	if steps == s {
+		steps = nil
+	}
+	if len(s.frames) < n {
+		return s.frames, fmt.Errorf("only sent %d of %d frames", len(s.frames), n)
+	}
+	return s.frames, nil
+}
+
+// stepping returns if a step is waiting for frames, playCondition.L must be
+// held
+func stepping() bool {
+	return steps != nil && steps.remaining > 0
+}
+
+// frameStepped records a frame sent while paused, status is nil when the
+// telemetry isn't known
+func frameStepped(frame, sourceFrame int, status *cptvframe.Telemetry) {
+	playCondition.L.Lock()
+	defer playCondition.L.Unlock()
+	if playing || !stepping() {
+		return
+	}
+	stepped := SteppedFrame{Frame: frame, SourceFrame: sourceFrame}
+	if status != nil {
+		stepped.Telemetry = &SteppedTelemetry{
+			TimeOn:       status.TimeOn.Milliseconds(),
+			FFCState:     status.FFCState,
+			FrameCount:   status.FrameCount,
+			FrameMean:    status.FrameMean,
+			TempC:        status.TempC,
+			LastFFCTempC: status.LastFFCTempC,
+			LastFFCTime:  status.LastFFCTime.Milliseconds(),
+		}
+	}
+	steps.frames = append(steps.frames, stepped)
+	steps.remaining--
+	if steps.remaining == 0 {
+		steps.finish()
+	}
+}
+
+// sourceFrame returns the frame of reader that was read last, or -1 if it
+// can't tell
+func sourceFrame(reader interface{}) int {
+	if c, ok := reader.(*cachedReader); ok {
+		return c.lastFrame()
+	}
+	if s, ok := reader.(seeker); ok {
+		return s.position() - 1
+	}
+	return -1
+}
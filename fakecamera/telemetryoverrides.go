@@ -0,0 +1,105 @@
+package fakecamera
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	cptvframe "github.com/TheCacophonyProject/go-cptv/cptvframe"
+)
+
+// statusFields are the telemetry fields every camera sends, overriding these
+// changes the frame status. Times are in seconds and temperatures in Celsius.
+var statusFields = map[string]func(t *cptvframe.Telemetry, value float64){
+	"tempc":        func(t *cptvframe.Telemetry, value float64) { t.TempC = value },
+	"lastffctempc": func(t *cptvframe.Telemetry, value float64) { t.LastFFCTempC = value },
+	"framecount":   func(t *cptvframe.Telemetry, value float64) { t.FrameCount = int(value) },
+	"framemean":    func(t *cptvframe.Telemetry, value float64) { t.FrameMean = uint16(value) },
+	"timeon":       func(t *cptvframe.Telemetry, value float64) { t.TimeOn = seconds(value) },
+	"timeonoffset": func(t *cptvframe.Telemetry, value float64) { t.TimeOn += seconds(value) },
+	"lastffctime":  func(t *cptvframe.Telemetry, value float64) { t.LastFFCTime = seconds(value) },
+}
+
+func seconds(value float64) time.Duration {
+	return time.Duration(value * float64(time.Second))
+}
+
+type telemetryOverride struct {
+	name  string
+	value expression
+}
+
+// telemetryOverrides are the telemetry fields set by a request, either to a
+// constant or an expression evaluated for every frame
+type telemetryOverrides struct {
+	status []telemetryOverride
+	fields []telemetryOverride
+}
+
+// parseTelemetryOverrides reads a JSON object of field names to numbers or
+// expressions e.g. {"TempC": "30 + t/60", "StatusBits": 0}. Names are either
+// a status field or a telemetry field of the emulated camera.
+func parseTelemetryOverrides(raw string) (*telemetryOverrides, error) {
+	o := &telemetryOverrides{}
+	if raw == "" {
+		return o, nil
+	}
+	var values map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &values); err != nil {
+		return nil, fmt.Errorf("could not parse telemetry %v", err)
+	}
+	for name, value := range values {
+		var expr expression
+		switch v := value.(type) {
+		case float64:
+			expr = constExpression(v)
+		case string:
+			var err error
+			if expr, err = parseExpression(v); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("telemetry %s must be a number or expression", name)
+		}
+
+		if _, ok := statusFields[strings.ToLower(name)]; ok {
+			o.status = append(o.status, telemetryOverride{strings.ToLower(name), expr})
+			continue
+		}
+		if err := setTelemetryField(camera.encoder.telemetryFields(), name, 0); err != nil {
+			return nil, err
+		}
+		o.fields = append(o.fields, telemetryOverride{name, expr})
+	}
+	return o, nil
+}
+
+// setStatus overrides the status of a frame
+func (o *telemetryOverrides) setStatus(t *cptvframe.Telemetry, vars *exprVars) {
+	for _, override := range o.status {
+		statusFields[override.name](t, override.value(vars))
+	}
+}
+
+// sets returns if the status field called name is overridden
+func (o *telemetryOverrides) sets(name string) bool {
+	for _, override := range o.status {
+		if override.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// rawFields returns the raw telemetry values to send with a frame
+func (o *telemetryOverrides) rawFields(vars *exprVars) map[string]float64 {
+	if len(o.fields) == 0 {
+		return nil
+	}
+	fields := make(map[string]float64, len(o.fields))
+	for _, override := range o.fields {
+		fields[override.name] = override.value(vars)
+	}
+	return fields
+}
@@ -0,0 +1,168 @@
+package fakecamera
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/url"
+	"strconv"
+	"time"
+
+	lepton3 "github.com/TheCacophonyProject/lepton3"
+)
+
+// Video over SPI layout of a Lepton 3 frame
+const (
+	vospiHeaderSize      = 4 // 2 byte ID, 2 byte CRC
+	vospiDataSize        = 160
+	vospiPacketSize      = vospiHeaderSize + vospiDataSize
+	vospiPacketsPerSeg   = 61
+	vospiSegmentsPerFrm  = 4
+	vospiSegmentPacket   = 20 // the packet holding the segment number
+	vospiDiscardID       = 0x0F00
+	vospiSegmentNumShift = 12
+)
+
+// vospiOptions are the fault knobs of a VoSPI output, probabilities are from
+// 0 to 1
+type vospiOptions struct {
+	badCRC      float64 // chance of each packet having a bad CRC
+	lostSegment float64 // chance of each segment not being sent
+	resync      float64 // chance of each frame stopping half way through a segment
+	discards    int     // discard packets sent between segments
+	seed        int64
+}
+
+func parseVospiOptions(q url.Values) (*vospiOptions, error) {
+	o := &vospiOptions{seed: time.Now().UnixNano()}
+	var err error
+	for key, value := range map[string]*float64{"bad-crc": &o.badCRC, "lost-segment": &o.lostSegment, "resync": &o.resync} {
+		if raw := q.Get(key); raw != "" {
+			if *value, err = strconv.ParseFloat(raw, 64); err != nil {
+				return nil, fmt.Errorf("invalid %s %q", key, raw)
+			}
+		}
+	}
+	if raw := q.Get("discards"); raw != "" {
+		if o.discards, err = strconv.Atoi(raw); err != nil {
+			return nil, fmt.Errorf("invalid discards %q", raw)
+		}
+	}
+	if raw := q.Get("seed"); raw != "" {
+		if o.seed, err = strconv.ParseInt(raw, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid seed %q", raw)
+		}
+	}
+	return o, nil
+}
+
+// vospiSink sends frames to another sink as the packet stream a Lepton 3
+// sends over SPI, instead of a header and whole frames
+type vospiSink struct {
+	sink
+	options *vospiOptions
+}
+
+func (s *vospiSink) String() string {
+	return "vospi " + s.sink.String()
+}
+
+func (s *vospiSink) connect() (io.WriteCloser, error) {
+	if camera.FrameSize() != lepton3.BytesPerFrame {
+		return nil, errors.New("vospi output needs a 160x120 lepton camera")
+	}
+	w, err := s.sink.connect()
+	if err != nil {
+		return nil, err
+	}
+	return &vospiWriter{WriteCloser: w, options: s.options, rand: rand.New(rand.NewSource(s.options.seed))}, nil
+}
+
+// vospiWriter splits each frame into segments of packets. Writes that are not
+// a whole frame, such as the header, are not part of the VoSPI stream so are
+// dropped.
+type vospiWriter struct {
+	io.WriteCloser
+	options *vospiOptions
+	rand    *rand.Rand
+	packets []byte
+}
+
+func (w *vospiWriter) Write(frame []byte) (int, error) {
+	if len(frame) != lepton3.BytesPerFrame {
+		return len(frame), nil
+	}
+	w.packets = w.packets[:0]
+	resyncSegment := -1
+	if w.rand.Float64() < w.options.resync {
+		resyncSegment = w.rand.Intn(vospiSegmentsPerFrm)
+	}
+	for segment := 0; segment < vospiSegmentsPerFrm; segment++ {
+		w.addDiscards()
+		if w.rand.Float64() < w.options.lostSegment {
+			continue
+		}
+		data := frame[segment*vospiPacketsPerSeg*vospiDataSize:]
+		for packet := 0; packet < vospiPacketsPerSeg; packet++ {
+			if segment == resyncSegment && packet == vospiPacketsPerSeg/2 {
+				// lose sync, the rest of this segment is never sent so the
+				// next packet is the first of the following segment
+				break
+			}
+			w.addPacket(segment+1, packet, data[packet*vospiDataSize:(packet+1)*vospiDataSize])
+		}
+	}
+	if _, err := w.WriteCloser.Write(w.packets); err != nil {
+		return 0, err
+	}
+	return len(frame), nil
+}
+
+func (w *vospiWriter) addDiscards() {
+	for i := 0; i < w.options.discards; i++ {
+		packet := make([]byte, vospiPacketSize)
+		binary.BigEndian.PutUint16(packet, vospiDiscardID|uint16(w.rand.Intn(0x100)))
+		w.packets = append(w.packets, packet...)
+	}
+}
+
+func (w *vospiWriter) addPacket(segment, packetNum int, data []byte) {
+	id := uint16(packetNum)
+	if packetNum == vospiSegmentPacket {
+		id |= uint16(segment) << vospiSegmentNumShift
+	}
+	packet := make([]byte, vospiPacketSize)
+	binary.BigEndian.PutUint16(packet, id)
+	copy(packet[vospiHeaderSize:], data)
+	crc := vospiCRC(packet)
+	if w.rand.Float64() < w.options.badCRC {
+		crc ^= uint16(1 + w.rand.Intn(0xFFFF))
+	}
+	binary.BigEndian.PutUint16(packet[2:], crc)
+	w.packets = append(w.packets, packet...)
+}
+
+// vospiCRC returns the CRC16-CCITT of a packet, calculated with the top four
+// bits of the ID and the CRC field zeroed
+func vospiCRC(packet []byte) uint16 {
+	var crc uint16
+	for i, b := range packet {
+		switch i {
+		case 0:
+			b &= 0x0F
+		case 2, 3:
+			b = 0
+		}
+		crc ^= uint16(b) << 8
+		for bit := 0; bit < 8; bit++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,113 @@
+package fakecamera
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"testing"
+
+	lepton3 "github.com/TheCacophonyProject/lepton3"
+)
+
+func TestVospiCRC(t *testing.T) {
+	// CRC16-CCITT (XModem) check value of "123456789" is 0x31C3, a leading
+	// header of zeros doesn't change it
+	packet := append(make([]byte, vospiHeaderSize), "123456789"...)
+	if crc := vospiCRC(packet); crc != 0x31C3 {
+		t.Errorf("vospiCRC = %#04x, want 0x31c3", crc)
+	}
+	// the top four bits of the ID and the CRC field aren't part of the CRC
+	packet[0], packet[2], packet[3] = 0xF0, 0x12, 0x34
+	if crc := vospiCRC(packet); crc != 0x31C3 {
+		t.Errorf("vospiCRC ignoring the header = %#04x, want 0x31c3", crc)
+	}
+	packet[1] = 1
+	if crc := vospiCRC(packet); crc == 0x31C3 {
+		t.Error("vospiCRC doesn't include the packet number")
+	}
+}
+
+// writeVospi returns the packets sent for frame
+func writeVospi(t *testing.T, options *vospiOptions, frame []byte) []byte {
+	var buf bytes.Buffer
+	w := &vospiWriter{WriteCloser: nopCloser{&buf}, options: options, rand: rand.New(rand.NewSource(1))}
+	if _, err := w.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func testFrame() []byte {
+	frame := make([]byte, lepton3.BytesPerFrame)
+	r := rand.New(rand.NewSource(2))
+	r.Read(frame)
+	return frame
+}
+
+func TestVospiPackets(t *testing.T) {
+	frame := testFrame()
+	stream := writeVospi(t, &vospiOptions{}, frame)
+	if len(stream) != vospiSegmentsPerFrm*vospiPacketsPerSeg*vospiPacketSize {
+		t.Fatalf("sent %d bytes, want %d", len(stream), vospiSegmentsPerFrm*vospiPacketsPerSeg*vospiPacketSize)
+	}
+
+	var data []byte
+	for i := 0; i < len(stream); i += vospiPacketSize {
+		packet := stream[i : i+vospiPacketSize]
+		segment, num := i/vospiPacketSize/vospiPacketsPerSeg+1, i/vospiPacketSize%vospiPacketsPerSeg
+		id := binary.BigEndian.Uint16(packet)
+		if int(id&0x0FFF) != num {
+			t.Fatalf("packet %d of segment %d has number %d", num, segment, id&0x0FFF)
+		}
+		wantSegment := 0
+		if num == vospiSegmentPacket {
+			wantSegment = segment
+		}
+		if got := int(id >> vospiSegmentNumShift); got != wantSegment {
+			t.Errorf("packet %d of segment %d has segment number %d, want %d", num, segment, got, wantSegment)
+		}
+		if crc := binary.BigEndian.Uint16(packet[2:]); crc != vospiCRC(packet) {
+			t.Errorf("packet %d of segment %d has CRC %#04x, want %#04x", num, segment, crc, vospiCRC(packet))
+		}
+		data = append(data, packet[vospiHeaderSize:]...)
+	}
+	if !bytes.Equal(data, frame) {
+		t.Error("packet data doesn't reassemble into the frame")
+	}
+}
+
+func TestVospiFaults(t *testing.T) {
+	frame := testFrame()
+	packets := func(stream []byte) int { return len(stream) / vospiPacketSize }
+
+	stream := writeVospi(t, &vospiOptions{badCRC: 1}, frame)
+	for i := 0; i < len(stream); i += vospiPacketSize {
+		packet := stream[i : i+vospiPacketSize]
+		if binary.BigEndian.Uint16(packet[2:]) == vospiCRC(packet) {
+			t.Fatalf("packet %d has a good CRC with bad-crc=1", i/vospiPacketSize)
+		}
+	}
+
+	stream = writeVospi(t, &vospiOptions{discards: 2}, frame)
+	if got, want := packets(stream), vospiSegmentsPerFrm*(vospiPacketsPerSeg+2); got != want {
+		t.Errorf("sent %d packets with 2 discards, want %d", got, want)
+	}
+	if id := binary.BigEndian.Uint16(stream); id&0x0F00 != vospiDiscardID {
+		t.Errorf("first packet ID %#04x isn't a discard packet", id)
+	}
+
+	stream = writeVospi(t, &vospiOptions{lostSegment: 1, discards: 1}, frame)
+	if got := packets(stream); got != vospiSegmentsPerFrm {
+		t.Errorf("sent %d packets with every segment lost, want only the %d discards", got, vospiSegmentsPerFrm)
+	}
+
+	stream = writeVospi(t, &vospiOptions{resync: 1}, frame)
+	if got, want := packets(stream), (vospiSegmentsPerFrm-1)*vospiPacketsPerSeg+vospiPacketsPerSeg/2; got != want {
+		t.Errorf("sent %d packets with a resync, want %d", got, want)
+	}
+
+	// frames that aren't a whole lepton frame, such as the header, are dropped
+	if stream := writeVospi(t, &vospiOptions{}, []byte("header\n")); len(stream) != 0 {
+		t.Errorf("sent %d bytes for the header", len(stream))
+	}
+}